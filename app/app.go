@@ -1,61 +1,415 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 	"gitlab.com/zap-api/app/handler"
+	"gitlab.com/zap-api/app/openapi"
+	"gitlab.com/zap-api/app/tracing"
 	"gitlab.com/zap-api/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
-// App has router
+// App has router, the Config and the Document are replaced by the reloads of the configuration
 type App struct {
-	Config *config.Config
-	Router *mux.Router
+	Config   *config.Config
+	Router   *mux.Router
+	Document *openapi.Document
+	mutex    sync.RWMutex
+	// reloading serializes the reloads of the SIGHUP and of the watch of the file
+	reloading sync.Mutex
+	reloads   reloadStatus
 }
 
-// App initialize with predefined configuration and check for environment variables
-func (a *App) Initialize(config *config.Config) {
+// App initialize with predefined configuration, returning every error of the configuration
+func (a *App) Initialize(config *config.Config) error {
 	a.Config = config
-	a.Config.Logger.Formatter = &log.TextFormatter{
-		FullTimestamp: true,
-	}
-	if os.Getenv("ZAP_PROPERTIES_ENDPOINT") == "" || os.Getenv("HOST") == "" {
-		a.Config.Logger.WithFields(log.Fields{
-			"ZAP_PROPERTIES_ENDPOINT": os.Getenv("ZAP_PROPERTIES_ENDPOINT"),
-			"HOST":                    os.Getenv("HOST"),
-		}).Error("Environment variables must be set.")
-		os.Exit(0)
+	a.setLogger(nil)
+	if err := a.Config.Validate(); err != nil {
+		a.Config.Logger.WithError(err).Error("Invalid configuration.")
+		return err
 	}
 	a.Config.Logger.Info("Initializing...")
+	a.Document = openapi.NewDocument(a.Config.SortedDatasources(), a.Config.Auth.KeysFile != "", *a.Config.Versions)
+	handler.RegisterMetrics(a.currentConfig)
 	a.Router = mux.NewRouter()
+	a.setTracing()
+	a.Router.Use(a.requestID, a.instrument, a.trace, a.validate)
 	a.setRouters()
+	a.reloads.loaded()
+	return nil
+}
+
+// setLogger formats the logs as JSON, unless the text format is configured for the terminal
+// on the reloads the level is set only when it changed in the configuration, keeping the one set by the admin
+func (a *App) setLogger(previous *config.Logging) {
+	current := a.currentConfig()
+	if current.Logging.Format == "text" {
+		current.Logger.SetFormatter(&log.TextFormatter{
+			FullTimestamp: true,
+		})
+	} else {
+		current.Logger.SetFormatter(&log.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			FieldMap: log.FieldMap{
+				log.FieldKeyMsg: "message",
+			},
+		})
+	}
+	if previous != nil && previous.Level == current.Logging.Level {
+		return
+	}
+	if level, err := log.ParseLevel(current.Logging.Level); err == nil {
+		current.Logger.SetLevel(level)
+	}
+}
+
+// setTracing sends the Spans to the OTLP collector in batches, or to the stdout, as configured
+// the errors of the exports are logged, and an exporter not created drops the Spans
+func (a *App) setTracing() {
+	tracing.SetLogger(a.Config.Logger)
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch a.Config.Tracing.Exporter {
+	case "otlp":
+		a.Config.Logger.WithField("endpoint", a.Config.Tracing.Endpoint).Info("Exporting traces to the collector")
+		exporter, err = tracing.NewOTLPExporter(a.Config.Tracing.Endpoint)
+	case "stdout":
+		exporter, err = tracing.NewStdoutExporter(os.Stdout)
+	}
+	if err != nil {
+		a.Config.Logger.WithError(err).Error("Traces not exported")
+		exporter = nil
+	}
+	tracing.SetExporter(exporter, a.Config.Tracing.ServiceName, a.Config.Tracing.Exporter == "otlp")
+}
+
+// Handler is the router with the CORS and the compression, they answer before the routes are matched
+func (a *App) Handler() http.Handler {
+	return a.cors(a.compress(a.Router))
 }
 
 // Set all required routers
 func (a *App) setRouters() {
 	a.Config.Logger.Info("Setting Routers...")
-	a.Get("/properties", a.GetAllProperties)
+	a.Get("/openapi.json", a.GetOpenAPI)
+	a.Get("/metrics", a.GetMetrics)
+	a.Get("/healthz", a.GetHealth)
+	a.Get("/readyz", a.GetReadiness)
+
+	admin := a.Admin(a.authorizeAdmin, a.rateLimit)
+	admin.Get("/log-level", a.GetLogLevel)
+	admin.Put("/log-level", a.SetLogLevel)
+	admin.Get("/config", a.GetConfigStatus)
+	admin.Get("/sources", a.GetSources)
+	admin.Get("/quotas", a.GetQuotas)
+	admin.Get("/quarantine", a.GetQuarantine)
+	admin.Get("/dead-letters", a.GetDeadLetters)
+	admin.Post("/dead-letters/{id}/retry", a.RetryDeadLetter)
+
+	routes := a.versionRoutes()
+	a.Version("v1", apiVersion("v1"), a.authenticate, a.rateLimit).handle(routes, "v1")
+	a.Version("v2", apiVersion("v2"), a.authenticate, a.rateLimit).handle(routes, "v2")
+	// The first clients call the API without the version, they are answered by the v1
+	a.Legacy("v1", apiVersion("v1"), a.authenticate, a.rateLimit).handle(routes, "v1")
+}
+
+// versionRoutes are the routes of every version, the v2 has its own handler of the Properties and of the exports
+func (a *App) versionRoutes() []route {
+	return []route{
+		{method: "GET", path: "/properties", handler: a.GetAllProperties, v2: a.GetAllPropertiesV2},
+		{method: "GET", path: "/properties/{id}/history", handler: a.GetPriceHistory},
+		{method: "GET", path: "/autocomplete", handler: a.Autocomplete},
+		{method: "GET", path: "/export/{source}", handler: a.Export, v2: a.ExportV2},
+		{method: "POST", path: "/exports", handler: a.CreateExport, v2: a.CreateExportV2},
+		{method: "GET", path: "/exports/{id}", handler: a.GetExport},
+		{method: "DELETE", path: "/exports/{id}", handler: a.CancelExport},
+		{method: "GET", path: "/exports/{id}/download", handler: a.DownloadExport},
+		{method: "GET", path: "/changes", handler: a.GetChanges},
+		{method: "GET", path: "/stream/{source}", handler: a.Stream},
+		{method: "POST", path: "/searches", handler: a.CreateSearch},
+		{method: "GET", path: "/searches", handler: a.ListSearches},
+		{method: "GET", path: "/searches/{id}", handler: a.GetSearch},
+		{method: "PUT", path: "/searches/{id}", handler: a.UpdateSearch},
+		{method: "DELETE", path: "/searches/{id}", handler: a.DeleteSearch},
+		{method: "GET", path: "/searches/{id}/deliveries", handler: a.GetDeliveries},
+	}
 }
 
 // Wrap the router for GET method
 func (a *App) Get(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	a.Router.HandleFunc(path, f).Methods("GET")
+	(&RouteGroup{Router: a.Router}).Get(path, f)
+}
+
+// Wrap the router for POST method
+func (a *App) Post(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	(&RouteGroup{Router: a.Router}).Post(path, f)
+}
+
+// Wrap the router for PUT method
+func (a *App) Put(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	(&RouteGroup{Router: a.Router}).Put(path, f)
+}
+
+// Wrap the router for PATCH method
+func (a *App) Patch(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	(&RouteGroup{Router: a.Router}).Patch(path, f)
+}
+
+// Wrap the router for DELETE method
+func (a *App) Delete(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	(&RouteGroup{Router: a.Router}).Delete(path, f)
 }
 
 // Handlers to manage Employee Data
 func (a *App) GetAllProperties(w http.ResponseWriter, r *http.Request) {
-	a.Config.Logger.WithFields(log.Fields{
-		"URL":    r.URL,
-		"header": r.Header,
-	}).Info("Requesting all Properties")
-	handler.GetAllProperties(a.Config, w, r)
+	handler.RequestLogger(a.currentConfig(), r).WithFields(log.Fields{
+		"URL":    r.URL.String(),
+		"header": handler.RedactHeaders(r.Header),
+	}).Debug("Requesting all Properties")
+	handler.GetAllProperties(a.currentConfig(), w, r)
+}
+
+// Handlers of the version 2, with the typed money fields
+func (a *App) GetAllPropertiesV2(w http.ResponseWriter, r *http.Request) {
+	handler.RequestLogger(a.currentConfig(), r).WithFields(log.Fields{
+		"URL":    r.URL.String(),
+		"header": handler.RedactHeaders(r.Header),
+	}).Debug("Requesting all Properties")
+	handler.GetAllPropertiesV2(a.currentConfig(), w, r)
+}
+
+// Streams every Property of the source for the bulk downloads
+func (a *App) Export(w http.ResponseWriter, r *http.Request) {
+	handler.Export(a.currentConfig(), w, r)
+}
+
+// Streams every Property of the source with the typed money fields
+func (a *App) ExportV2(w http.ResponseWriter, r *http.Request) {
+	handler.ExportV2(a.currentConfig(), w, r)
+}
+
+// Starts a job exporting the Properties of the source to an artifact
+func (a *App) CreateExport(w http.ResponseWriter, r *http.Request) {
+	handler.CreateExport(a.currentConfig(), w, r)
+}
+
+// Starts a job exporting the Properties of the source with the typed money fields
+func (a *App) CreateExportV2(w http.ResponseWriter, r *http.Request) {
+	handler.CreateExportV2(a.currentConfig(), w, r)
+}
+
+// Status of the export job
+func (a *App) GetExport(w http.ResponseWriter, r *http.Request) {
+	handler.GetExport(a.currentConfig(), w, r)
+}
+
+// Cancels the export job
+func (a *App) CancelExport(w http.ResponseWriter, r *http.Request) {
+	handler.CancelExport(a.currentConfig(), w, r)
+}
+
+// Downloads the artifact of the export job
+func (a *App) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	handler.DownloadExport(a.currentConfig(), w, r)
+}
+
+// Prices observed for the Property of the source
+func (a *App) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	handler.GetPriceHistory(a.currentConfig(), w, r)
+}
+
+// Changes of the Properties of the source between the snapshots
+func (a *App) GetChanges(w http.ResponseWriter, r *http.Request) {
+	handler.GetChanges(a.currentConfig(), w, r)
+}
+
+// Pushes the changes of the source as Server-Sent Events
+func (a *App) Stream(w http.ResponseWriter, r *http.Request) {
+	handler.Stream(a.currentConfig(), w, r)
 }
 
-// Run the app on it's router
+// Saves a search, notified by webhook of its new Properties
+func (a *App) CreateSearch(w http.ResponseWriter, r *http.Request) {
+	handler.CreateSearch(a.currentConfig(), w, r)
+}
+
+// Lists the saved searches of the client
+func (a *App) ListSearches(w http.ResponseWriter, r *http.Request) {
+	handler.ListSearches(a.currentConfig(), w, r)
+}
+
+// Shows a saved search
+func (a *App) GetSearch(w http.ResponseWriter, r *http.Request) {
+	handler.GetSearch(a.currentConfig(), w, r)
+}
+
+// Replaces a saved search
+func (a *App) UpdateSearch(w http.ResponseWriter, r *http.Request) {
+	handler.UpdateSearch(a.currentConfig(), w, r)
+}
+
+// Removes a saved search
+func (a *App) DeleteSearch(w http.ResponseWriter, r *http.Request) {
+	handler.DeleteSearch(a.currentConfig(), w, r)
+}
+
+// History of the webhooks of a saved search
+func (a *App) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	handler.GetDeliveries(a.currentConfig(), w, r)
+}
+
+// Webhooks dead after the last attempt
+func (a *App) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	handler.GetDeadLetters(a.currentConfig(), w, r)
+}
+
+// Delivers a dead webhook again
+func (a *App) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	handler.RetryDeadLetter(a.currentConfig(), w, r)
+}
+
+// Publishes the OpenAPI document describing the routes
+func (a *App) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	handler.GetOpenAPI(a.currentDocument(), w, r)
+}
+
+// Exposes the metrics to the Prometheus scrapes
+func (a *App) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	handler.GetMetrics(w, r)
+}
+
+// Suggests the places for the autocomplete of the portals
+func (a *App) Autocomplete(w http.ResponseWriter, r *http.Request) {
+	handler.RequestLogger(a.currentConfig(), r).WithFields(log.Fields{
+		"URL": r.URL.String(),
+	}).Debug("Requesting the autocomplete")
+	handler.Autocomplete(a.currentConfig(), w, r)
+}
+
+// Liveness for the orchestrator
+func (a *App) GetHealth(w http.ResponseWriter, r *http.Request) {
+	handler.GetHealth(w, r)
+}
+
+// Readiness for the orchestrator, with the status of the snapshot of every source
+func (a *App) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	handler.GetReadiness(a.currentConfig(), w, r)
+}
+
+// Shows the level of the Logger
+func (a *App) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	handler.GetLogLevel(a.currentConfig(), w, r)
+}
+
+// Changes the level of the Logger while the API is running
+func (a *App) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	handler.SetLogLevel(a.currentConfig(), w, r)
+}
+
+// Status of the reloads of the configuration
+func (a *App) GetConfigStatus(w http.ResponseWriter, r *http.Request) {
+	status := a.reloads.get()
+	status.File = a.currentConfig().File
+	handler.GetConfigStatus(&status, w, r)
+}
+
+// Lists the sources configured
+func (a *App) GetSources(w http.ResponseWriter, r *http.Request) {
+	handler.GetSources(a.currentConfig(), w, r)
+}
+
+// Requests of the day of every client
+func (a *App) GetQuotas(w http.ResponseWriter, r *http.Request) {
+	handler.GetQuotas(a.currentConfig(), w, r)
+}
+
+// Properties kept out of the snapshots by their anomalies
+func (a *App) GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	handler.GetQuarantine(a.currentConfig(), w, r)
+}
+
+// Run the app on it's router until the SIGTERM or SIGINT, the SIGHUP reloads the configuration
 func (a *App) Run(host string) {
-	a.Config.Logger.Info("Listening to the port", host)
-	log.Fatal(http.ListenAndServe(host, a.Router))
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		a.currentConfig().Logger.WithField("signal", (<-signals).String()).Info("Shutting down...")
+		cancel()
+	}()
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			a.currentConfig().Logger.Info("Reloading the configuration...")
+			a.Reload(ctx)
+		}
+	}()
+	if err := a.Serve(ctx, host); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Serve the app until the context is done, then the ingestion and the export jobs are cancelled
+// and the requests in flight are drained until the shutdown timeout
+func (a *App) Serve(ctx context.Context, host string) error {
+	settings := a.currentConfig().Server
+	server := &http.Server{
+		Addr:              host,
+		Handler:           a.Handler(),
+		ReadTimeout:       settings.ReadTimeout,
+		ReadHeaderTimeout: settings.ReadHeaderTimeout,
+		WriteTimeout:      settings.WriteTimeout,
+		IdleTimeout:       settings.IdleTimeout,
+		MaxHeaderBytes:    settings.MaxHeaderBytes,
+	}
+	// The streams never become idle, they are ended so the shutdown does not wait for them
+	server.RegisterOnShutdown(handler.CloseStreams)
+	ingestionCtx, cancelIngestion := context.WithCancel(ctx)
+	defer cancelIngestion()
+	ingestionDone := make(chan struct{})
+	go func() {
+		handler.RunIngestion(ingestionCtx, a.currentConfig)
+		close(ingestionDone)
+	}()
+	go a.watchConfig(ingestionCtx)
+	serverErr := make(chan error, 1)
+	go func() {
+		a.currentConfig().Logger.WithField("host", host).Info("Listening to the port")
+		serverErr <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+	cancelIngestion()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		a.currentConfig().Logger.WithError(err).Error("Requests in flight were dropped")
+		return err
+	}
+	select {
+	case <-ingestionDone:
+	case <-shutdownCtx.Done():
+		a.currentConfig().Logger.Error("Ingestion did not stop before the shutdown timeout")
+	}
+	if err := handler.StopExports(shutdownCtx); err != nil {
+		a.currentConfig().Logger.WithError(err).Error("Export jobs did not stop before the shutdown timeout")
+	}
+	if err := tracing.Shutdown(shutdownCtx); err != nil {
+		a.currentConfig().Logger.WithError(err).Error("Spans not exported before the shutdown timeout")
+	}
+	a.currentConfig().Logger.Info("Shutdown complete")
+	return nil
 }
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encoder is the writer of a content coding, the brotli and the gzip ones are reused by their pools
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+// encoders of the content codings offered, in the order preferred when the clients accept them equally
+var encoders = []struct {
+	coding string
+	pool   *sync.Pool
+}{
+	{"br", &sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, brotli.DefaultCompression) }}},
+	{"gzip", &sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}},
+}
+
+// compress encodes the responses with the coding of the highest quality accepted by the client, brotli or gzip
+func (a *App) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.currentConfig().Server.Compression || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		coding, pool := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if pool == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &compressResponseWriter{ResponseWriter: w, coding: coding, pool: pool}
+		defer writer.Close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// negotiateEncoding reads the Accept-Encoding, like "br;q=1.0, gzip;q=0.8, *;q=0.1", and returns the coding offered
+// of the highest quality with its pool, the "*" is the quality of the codings not named, nil is the identity
+func negotiateEncoding(acceptEncoding string) (string, *sync.Pool) {
+	qualities, wildcard := map[string]float64{}, 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+		for _, parameter := range fields[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				if q, err := strconv.ParseFloat(parameter[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = quality
+		} else if coding != "" {
+			qualities[coding] = quality
+		}
+	}
+	best, bestQuality := -1, 0.0
+	for i, offered := range encoders {
+		quality, ok := qualities[offered.coding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best, bestQuality = i, quality
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+	return encoders[best].coding, encoders[best].pool
+}
+
+// compressResponseWriter starts the encoder on the first write, the responses without body or already encoded are not changed
+type compressResponseWriter struct {
+	http.ResponseWriter
+	coding      string
+	pool        *sync.Pool
+	encoder     encoder
+	wroteHeader bool
+}
+
+func (c *compressResponseWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	header := c.ResponseWriter.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK &&
+		header.Get("Content-Encoding") == "" && header.Get("Content-Type") != "application/gzip" {
+		header.Set("Content-Encoding", c.coding)
+		header.Del("Content-Length")
+		c.encoder = c.pool.Get().(encoder)
+		c.encoder.Reset(c.ResponseWriter)
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.ResponseWriter.Header().Get("Content-Type") == "" {
+			c.ResponseWriter.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.encoder == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.encoder.Write(b)
+}
+
+// Flush sends the compressed bytes written so far, so the streams are not held by the encoder
+func (c *compressResponseWriter) Flush() {
+	if c.encoder != nil {
+		c.encoder.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets the http.ResponseController reach the connection through the encoder
+func (c *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Close ends the encoded stream and returns the encoder to its pool
+func (c *compressResponseWriter) Close() {
+	if c.encoder == nil {
+		return
+	}
+	c.encoder.Close()
+	c.pool.Put(c.encoder)
+	c.encoder = nil
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/zap-api/config"
+)
+
+// corsHeaders are the headers the browsers may send, the source and the API keys included
+var corsHeaders = []string{"Authorization", "X-API-Key", "X-Request-ID", "If-None-Match", "If-Modified-Since", "Traceparent", "Source"}
+
+// corsExposed are the headers of the responses the front-ends may read
+var corsExposed = []string{"ETag", "Last-Modified", "X-Request-ID", "API-Version", "Deprecation", "Sunset", "Link",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
+
+// cors answers the preflights and allows the origins of the source of the request, it is outside of the router
+// since the preflights have no route, the origins not allowed get no CORS headers and are blocked by the browsers
+func (a *App) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		config := a.currentConfig()
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		// The preflights have no source header, and the source of the API keys is only known after the authentication
+		source := r.Header.Get("source")
+		if preflight {
+			source = ""
+		}
+		if allowedOrigin(config, source, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposed, ", "))
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORS.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowedOrigin tells if the origin is allowed for the source, or for any source when it is not informed
+func allowedOrigin(config *config.Config, source, origin string) bool {
+	for name, origins := range config.CORS.Origins {
+		if source != "" && name != source {
+			continue
+		}
+		for _, allowed := range origins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+	}
+	return false
+}
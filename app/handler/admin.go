@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// GetLogLevel responds the current level of the Logger
+func GetLogLevel(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, &model.LogLevel{Level: config.Logger.GetLevel().String()})
+}
+
+// SetLogLevel changes the level of the Logger without restarting the API
+func SetLogLevel(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	logger := RequestLogger(config, r)
+	logLevel := model.LogLevel{}
+	if err := json.NewDecoder(r.Body).Decode(&logLevel); err != nil {
+		logger.WithError(err).Error("Invalid body for the log level")
+		respondError(w, http.StatusBadRequest, "Invalid body.")
+		return
+	}
+	level, err := logrus.ParseLevel(logLevel.Level)
+	if err != nil {
+		logger.WithField("level", logLevel.Level).Error("Log level not accepted")
+		respondError(w, http.StatusBadRequest, "Level not accepted.")
+		return
+	}
+	config.Logger.SetLevel(level)
+	logger.WithField("level", level.String()).Warn("Log level changed")
+	respondJSON(w, http.StatusOK, &model.LogLevel{Level: level.String()})
+}
+
+// GetConfigStatus responds the status of the reloads of the configuration
+func GetConfigStatus(status *model.ConfigStatus, w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// Severities of the anomalies, ranked so the quarantine keeps out its severity and the ones above
+const (
+	severityMedium = "medium"
+	severityHigh   = "high"
+)
+
+var severities = map[string]int{severityMedium: 1, severityHigh: 2}
+
+// Reasons of the anomalies of the prices
+const (
+	anomalySquareMeterLow  = "price_per_square_meter_low"
+	anomalySquareMeterHigh = "price_per_square_meter_high"
+	anomalyCondoFee        = "condo_fee_above_rent"
+)
+
+// madScale makes the MAD comparable to the standard deviation of a normal distribution
+const madScale = 0.6745
+
+// quarantine has the Properties kept out of the last snapshot of every source
+var quarantine = struct {
+	sync.RWMutex
+	sources map[string][]model.Property
+}{sources: map[string][]model.Property{}}
+
+// detectAnomalies sets the Anomalies of the Properties of a source, the prices are the upstream ones by their IDs
+// so the campaigns do not make outliers, it returns the Properties kept and the ones in quarantine
+func detectAnomalies(anomalies *config.Anomalies, properties []model.Property, originals map[string]string) ([]model.Property, []model.Property) {
+	groups := map[string][]int{}
+	squareMeterPrices := make([]float64, len(properties))
+	for i := range properties {
+		property := &properties[i]
+		property.Anomalies = nil
+		price, ok := parsePrice(originals[property.Id])
+		if !ok {
+			continue
+		}
+		if fee, ok := parsePrice(property.PricingInfos.MonthlyCondoFee); ok && property.PricingInfos.BusinessType == "RENTAL" && fee > price {
+			property.Anomalies = append(property.Anomalies, model.Anomaly{Reason: anomalyCondoFee, Severity: severityHigh})
+		}
+		if property.UsableAreas > 0 {
+			squareMeterPrices[i] = price / float64(property.UsableAreas)
+			key := property.Address.Neighborhood + "|" + property.PricingInfos.BusinessType
+			groups[key] = append(groups[key], i)
+		}
+	}
+	for _, group := range groups {
+		if len(group) < anomalies.MinGroupSize {
+			continue
+		}
+		values := make([]float64, 0, len(group))
+		for _, i := range group {
+			values = append(values, squareMeterPrices[i])
+		}
+		center := median(values)
+		deviations := make([]float64, 0, len(group))
+		for _, value := range values {
+			deviations = append(deviations, math.Abs(value-center))
+		}
+		// Most of the group has the same price per square meter, the outliers can not be told apart
+		mad := median(deviations)
+		if mad == 0 {
+			continue
+		}
+		for _, i := range group {
+			score := madScale * (squareMeterPrices[i] - center) / mad
+			if math.Abs(score) < anomalies.Threshold {
+				continue
+			}
+			anomaly := model.Anomaly{Reason: anomalySquareMeterHigh, Severity: severityMedium, Score: math.Round(score*100) / 100}
+			if score < 0 {
+				anomaly.Reason = anomalySquareMeterLow
+			}
+			if math.Abs(score) >= anomalies.HighThreshold {
+				anomaly.Severity = severityHigh
+			}
+			properties[i].Anomalies = append(properties[i].Anomalies, anomaly)
+		}
+	}
+	if anomalies.Quarantine == "" {
+		return properties, nil
+	}
+	kept, quarantined := []model.Property{}, []model.Property{}
+	for i := range properties {
+		if inQuarantine(anomalies, &properties[i]) {
+			quarantined = append(quarantined, properties[i])
+		} else {
+			kept = append(kept, properties[i])
+		}
+	}
+	return kept, quarantined
+}
+
+// inQuarantine tells if the Property has an anomaly of the quarantine severity or above
+func inQuarantine(anomalies *config.Anomalies, property *model.Property) bool {
+	for _, anomaly := range property.Anomalies {
+		if severities[anomaly.Severity] >= severities[anomalies.Quarantine] {
+			return true
+		}
+	}
+	return false
+}
+
+// median of the values, they are sorted in place
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// GetQuarantine responds the Properties of every source kept out of the last snapshot by their anomalies
+func GetQuarantine(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	response := model.Quarantine{Sources: map[string][]model.PropertyV2{}}
+	quarantine.RLock()
+	defer quarantine.RUnlock()
+	for _, source := range config.SortedDatasources() {
+		properties := []model.PropertyV2{}
+		for i := range quarantine.sources[source] {
+			properties = append(properties, toPropertyV2(&quarantine.sources[source][i]))
+		}
+		response.Sources[source] = properties
+	}
+	respondJSON(w, http.StatusOK, &response)
+}
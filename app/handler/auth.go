@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"gitlab.com/zap-api/config"
+)
+
+const clientKey contextKey = "client"
+
+// APIKeyHeader is the header of the API key for the clients not sending the Authorization Bearer
+const APIKeyHeader = "X-API-Key"
+
+// Client is the client authenticated by the API key of the request
+type Client struct {
+	Name string
+	*config.Client
+}
+
+// Authenticate finds the client of the API key and keeps it in the context of the request
+// the source is taken from the key when the header is absent, and it must be one of the sources of the client
+// it responds 401 without a valid key and 403 for the sources not allowed, returning nil
+func Authenticate(config *config.Config, w http.ResponseWriter, r *http.Request) *http.Request {
+	if config.Auth.KeysFile == "" {
+		return r
+	}
+	r = identify(config, w, r)
+	if r == nil {
+		return nil
+	}
+	client := ClientOf(r)
+	source := r.Header.Get("source")
+	if source == "" {
+		r.Header.Set("source", client.Sources[0])
+		return r
+	}
+	for _, allowed := range client.Sources {
+		if allowed == source {
+			return r
+		}
+	}
+	RequestLogger(config, r).WithField("source", source).Error("Source not allowed for the client")
+	respondError(w, http.StatusForbidden, "Source not allowed.")
+	return nil
+}
+
+// AuthorizeAdmin lets just the admin clients call the administration routes, returning nil otherwise
+// the administration is closed with 403 while the API keys are not configured
+func AuthorizeAdmin(config *config.Config, w http.ResponseWriter, r *http.Request) *http.Request {
+	if config.Auth.KeysFile == "" {
+		RequestLogger(config, r).Error("Administration not allowed without the API keys")
+		respondError(w, http.StatusForbidden, "Administration not allowed without the API keys.")
+		return nil
+	}
+	r = identify(config, w, r)
+	if r == nil {
+		return nil
+	}
+	if !ClientOf(r).Admin {
+		RequestLogger(config, r).Error("Administration not allowed for the client")
+		respondError(w, http.StatusForbidden, "Administration not allowed.")
+		return nil
+	}
+	return r
+}
+
+// identify finds the client by the hash of the key, comparing every hash in constant time
+func identify(config *config.Config, w http.ResponseWriter, r *http.Request) *http.Request {
+	logger := RequestLogger(config, r)
+	key := apiKey(r)
+	if key == "" {
+		logger.Error("API key not informed")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="zap-api"`)
+		respondError(w, http.StatusUnauthorized, "API key required.")
+		return nil
+	}
+	hash := sha256.Sum256([]byte(key))
+	keyHash := []byte(hex.EncodeToString(hash[:]))
+	var found *Client
+	for name, client := range config.Clients {
+		if subtle.ConstantTimeCompare(keyHash, []byte(strings.ToLower(client.KeyHash))) == 1 {
+			found = &Client{Name: name, Client: client}
+		}
+	}
+	if found == nil {
+		logger.Error("API key not accepted")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="zap-api", error="invalid_token"`)
+		respondError(w, http.StatusUnauthorized, "Invalid API key.")
+		return nil
+	}
+	return r.WithContext(context.WithValue(r.Context(), clientKey, found))
+}
+
+// apiKey reads the key of the Authorization Bearer, or of the X-API-Key header
+func apiKey(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > len("Bearer ") && strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(authorization[len("Bearer "):])
+	}
+	return strings.TrimSpace(r.Header.Get(APIKeyHeader))
+}
+
+// ClientOf returns the client authenticated, or nil when the API keys are not required
+func ClientOf(r *http.Request) *Client {
+	client, _ := r.Context().Value(clientKey).(*Client)
+	return client
+}
+
+// sourceAllowed tells if the client may read the source of the path, any client may when the API keys are not required
+func sourceAllowed(r *http.Request, source string) bool {
+	client := ClientOf(r)
+	if client == nil {
+		return true
+	}
+	for _, allowed := range client.Sources {
+		if allowed == source {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedSources are the sources enabled the client may read, every one of them when the API keys are not required
+func allowedSources(config *config.Config, r *http.Request) []string {
+	client := ClientOf(r)
+	if client == nil {
+		return config.SortedDatasources()
+	}
+	sources := []string{}
+	for _, source := range client.Sources {
+		if config.Enabled(source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/tracing"
+	"gitlab.com/zap-api/config"
+)
+
+// Autocomplete suggests the Cities and Neighborhoods starting with the query, with the Properties count of every source
+func Autocomplete(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	logger := RequestLogger(config, r).WithField("query", query)
+	logger.Info("Suggesting places")
+	if strings.TrimSpace(query) == "" {
+		logger.Error("No query for the autocomplete")
+		respondError(w, http.StatusBadRequest, "Query not informed.")
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	snapshots := map[string]*snapshot{}
+	for _, source := range allowedSources(config, r) {
+		propertiesSnapshot := getSnapshotOr404(config, source, w, r)
+		if propertiesSnapshot == nil {
+			return
+		}
+		snapshots[source] = propertiesSnapshot
+	}
+	suggestions := suggest(snapshots, query, limit)
+	_, span := tracing.Start(r.Context(), "serialize response")
+	respondJSON(w, http.StatusOK, &model.AutocompleteResponse{
+		Suggestions: suggestions,
+	})
+	span.End()
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified sets the ETag and the Last-Modified of the page, derived from the version of the snapshot and the parameters
+// it responds 304 and returns true when the client has the page of this snapshot already
+func notModified(w http.ResponseWriter, r *http.Request, source string, s *snapshot) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(r.URL.Path + "\n" + source + "\n" + r.URL.Query().Encode()))
+	// The ETag is weak, the compressed responses have other bytes but the same Properties
+	etag := fmt.Sprintf(`W/"%x-%x"`, s.Version, hash.Sum64())
+	lastModified := s.CreatedAt.UTC().Truncate(time.Second)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || lastModified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares the ETags of the If-None-Match with the weak comparison
+func etagMatches(match, etag string) bool {
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// Types of the changes between two snapshots
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// changeFields are compared between the snapshots, named by their JSON paths
+// the updatedAt is the upstream one, the campaigns set it to the time of every ingestion
+var changeFields = []struct {
+	name  string
+	value func(s *snapshot, property *model.Property) string
+}{
+	{"pricingInfos.price", func(s *snapshot, property *model.Property) string { return property.PricingInfos.Price }},
+	{"pricingInfos.monthlyCondoFee", func(s *snapshot, property *model.Property) string { return property.PricingInfos.MonthlyCondoFee }},
+	{"pricingInfos.rentalTotalPrice", func(s *snapshot, property *model.Property) string { return property.PricingInfos.RentalTotalPrice }},
+	{"pricingInfos.businessType", func(s *snapshot, property *model.Property) string { return property.PricingInfos.BusinessType }},
+	{"listingStatus", func(s *snapshot, property *model.Property) string { return property.ListingStatus }},
+	{"updatedAt", func(s *snapshot, property *model.Property) string { return s.upstreamUpdatedAt[property.Id].value }},
+}
+
+// sourceChanges has the last snapshot of the source, the base of the next diff
+// the changes are in the order of the snapshots, and the base is the version they start from
+type sourceChanges struct {
+	last    *snapshot
+	base    int64
+	changes []model.Change
+}
+
+// changeFeed keeps the changes of every source enabled, they are recorded on every new snapshot
+// the updated channel is closed on every record, waking up the streams
+type changeFeed struct {
+	mutex   sync.RWMutex
+	sources map[string]*sourceChanges
+	updated chan struct{}
+}
+
+var changes = &changeFeed{sources: map[string]*sourceChanges{}, updated: make(chan struct{})}
+
+// record diffs the new snapshots against the last ones, the first snapshot of a source is just the base
+// the changes older than the retention are removed, then the base is their version
+// it returns the changes of every source recorded
+func (f *changeFeed) record(config *config.Config, snapshots map[string]*snapshot) map[string][]model.Change {
+	recorded := map[string][]model.Change{}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for name := range f.sources {
+		if _, ok := snapshots[name]; !ok {
+			delete(f.sources, name)
+		}
+	}
+	for name, current := range snapshots {
+		feed, ok := f.sources[name]
+		if !ok {
+			f.sources[name] = &sourceChanges{last: current, base: current.Version}
+			continue
+		}
+		// A snapshot built by a request without cache can finish after a newer ingestion
+		if current.Version <= feed.last.Version {
+			continue
+		}
+		recorded[name] = diffSnapshots(feed.last, current)
+		feed.changes = append(feed.changes, recorded[name]...)
+		feed.last = current
+		cutoff := current.CreatedAt.Add(-config.Changes.Retention).UnixNano()
+		expired := 0
+		for expired < len(feed.changes) && feed.changes[expired].SnapshotVersion < cutoff {
+			feed.base = feed.changes[expired].SnapshotVersion
+			expired++
+		}
+		if expired > 0 {
+			feed.changes = append([]model.Change(nil), feed.changes[expired:]...)
+		}
+	}
+	close(f.updated)
+	f.updated = make(chan struct{})
+	return recorded
+}
+
+// wait returns the channel closed on the next record
+func (f *changeFeed) wait() <-chan struct{} {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.updated
+}
+
+// after returns the changes after the change of the ID in the version, an empty ID is after every change of the version
+// it returns false when the changes after the version are not kept anymore
+func (f *changeFeed) after(name string, version int64, id string) ([]model.Change, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	feed, ok := f.sources[name]
+	if !ok || version < feed.base {
+		return nil, false
+	}
+	first := sort.Search(len(feed.changes), func(i int) bool {
+		change := feed.changes[i]
+		return change.SnapshotVersion > version || (change.SnapshotVersion == version && id != "" && change.Id > id)
+	})
+	return feed.changes[first:], true
+}
+
+// lastVersion is the version of the last snapshot of the source, zero before the first one
+func (f *changeFeed) lastVersion(name string) int64 {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if feed, ok := f.sources[name]; ok {
+		return feed.last.Version
+	}
+	return 0
+}
+
+// since returns the changes after the version, and the version of the last snapshot
+// a negative version is the base, it returns false when the changes since the version are not kept anymore
+func (f *changeFeed) since(name string, version int64) ([]model.Change, int64, int64, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	feed, ok := f.sources[name]
+	if !ok {
+		return nil, 0, version, false
+	}
+	if version < 0 {
+		version = feed.base
+	}
+	if version < feed.base {
+		return nil, feed.last.Version, version, false
+	}
+	first := sort.Search(len(feed.changes), func(i int) bool { return feed.changes[i].SnapshotVersion > version })
+	return feed.changes[first:], feed.last.Version, version, true
+}
+
+// diffSnapshots returns the Properties added, removed and changed, in the order of their IDs
+func diffSnapshots(previous, current *snapshot) []model.Change {
+	before := make(map[string]*model.Property, len(previous.Properties))
+	for i := range previous.Properties {
+		before[previous.Properties[i].Id] = &previous.Properties[i]
+	}
+	found := make(map[string]bool, len(current.Properties))
+	diff := []model.Change{}
+	for i := range current.Properties {
+		property := &current.Properties[i]
+		found[property.Id] = true
+		old, ok := before[property.Id]
+		if !ok {
+			diff = append(diff, model.Change{SnapshotVersion: current.Version, Type: changeAdded, Id: property.Id})
+			continue
+		}
+		fields := map[string]model.FieldChange{}
+		for _, field := range changeFields {
+			if from, to := field.value(previous, old), field.value(current, property); from != to {
+				fields[field.name] = model.FieldChange{From: from, To: to}
+			}
+		}
+		if len(fields) > 0 {
+			diff = append(diff, model.Change{SnapshotVersion: current.Version, Type: changeChanged, Id: property.Id, Fields: fields})
+		}
+	}
+	for id := range before {
+		if !found[id] {
+			diff = append(diff, model.Change{SnapshotVersion: current.Version, Type: changeRemoved, Id: id})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Id < diff[j].Id })
+	return diff
+}
+
+// GetChanges answers the changes of the source since the snapshot version, paginated like the Properties
+func GetChanges(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	logger := RequestLogger(config, r).WithField("source", source)
+	if !config.Enabled(source) {
+		logger.Error("No change found for the source")
+		respondError(w, http.StatusNotFound, "Source not accepted.")
+		return
+	}
+	if !sourceAllowed(r, source) {
+		logger.Error("Source not allowed for the client")
+		respondError(w, http.StatusForbidden, "Source not allowed.")
+		return
+	}
+	if getSnapshotOr404(config, source, w, r) == nil {
+		return
+	}
+	since := int64(-1)
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			logger.WithField("since", value).Error("Invalid snapshot version")
+			respondError(w, http.StatusBadRequest, "Invalid request: parameter since must be a snapshot version.")
+			return
+		}
+		since = parsed
+	}
+	sourceChanges, version, since, ok := changes.since(source, since)
+	if !ok {
+		logger.WithField("since", since).Error("Changes since the version are not kept")
+		respondError(w, http.StatusGone, "Changes since the version are not kept, export the source again.")
+		return
+	}
+	offset, limit := pageParams(logger, r, config.RateLimit.MaxPageSize)
+	start := len(sourceChanges)
+	if offset <= len(sourceChanges)/limit {
+		start = offset * limit
+	}
+	end := start + limit
+	if end > len(sourceChanges) {
+		end = len(sourceChanges)
+	}
+	respondJSON(w, http.StatusOK, &model.ChangesResponse{
+		Source:            source,
+		Since:             since,
+		SnapshotVersion:   version,
+		Changes:           append([]model.Change{}, sourceChanges[start:end]...),
+		PageNumber:        offset,
+		PageSize:          limit,
+		ChangesTotalCount: len(sourceChanges),
+	})
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// maxImageListings is the most of Properties of a bucket with the same image URL compared by it,
+// the ones used by more are placeholders or logos of the agencies
+const maxImageListings = 20
+
+// duplicateGroups is a union-find of the positions of the Properties, every group is kept by its root
+type duplicateGroups []int
+
+func (g duplicateGroups) root(i int) int {
+	for g[i] != i {
+		g[i] = g[g[i]]
+		i = g[i]
+	}
+	return i
+}
+
+func (g duplicateGroups) union(i, j int) {
+	if first, second := g.root(i), g.root(j); first != second {
+		g[second] = first
+	}
+}
+
+// markDuplicates sets the DuplicateGroupId of the probable duplicates among the Properties of a source
+// the candidates are compared inside the buckets of the same business type, bedrooms and bathrooms
+func markDuplicates(dedup *config.Dedup, properties []model.Property) {
+	groups := make(duplicateGroups, len(properties))
+	buckets := map[string][]int{}
+	for i := range properties {
+		groups[i] = i
+		properties[i].DuplicateGroupId = ""
+		property := &properties[i]
+		key := property.PricingInfos.BusinessType + "|" + strconv.Itoa(property.Bedrooms) + "|" + strconv.Itoa(property.Bathrooms)
+		buckets[key] = append(buckets[key], i)
+	}
+	for _, bucket := range buckets {
+		if dedup.Distance > 0 {
+			groupNearby(dedup, properties, bucket, groups)
+		}
+		if dedup.MinSharedImages > 0 {
+			groupSharedImages(dedup, properties, bucket, groups)
+		}
+	}
+	members := map[int][]string{}
+	for i := range properties {
+		root := groups.root(i)
+		members[root] = append(members[root], properties[i].Id)
+	}
+	for i := range properties {
+		ids := members[groups.root(i)]
+		if len(ids) < 2 {
+			continue
+		}
+		properties[i].DuplicateGroupId = duplicateGroupID(ids)
+	}
+}
+
+// groupNearby joins the Properties of the bucket within the distance, sweeping them in the order of the latitude
+func groupNearby(dedup *config.Dedup, properties []model.Property, bucket []int, groups duplicateGroups) {
+	// The Properties without coordinates are rejected by the ingestion
+	located := append([]int(nil), bucket...)
+	sort.Slice(located, func(a, b int) bool {
+		return properties[located[a]].Address.GeoLocation.Location.Lat < properties[located[b]].Address.GeoLocation.Location.Lat
+	})
+	// The degrees of latitude of the distance, the Properties farther in latitude are never within it
+	window := dedup.Distance / earthRadius * 180 / math.Pi
+	for a, i := range located {
+		first := &properties[i]
+		for _, j := range located[a+1:] {
+			second := &properties[j]
+			if second.Address.GeoLocation.Location.Lat-first.Address.GeoLocation.Location.Lat > window {
+				break
+			}
+			if distance(first.Address.GeoLocation.Location, second.Address.GeoLocation.Location) <= dedup.Distance &&
+				similarListing(dedup, first, second) {
+				groups.union(i, j)
+			}
+		}
+	}
+}
+
+// groupSharedImages joins the Properties of the bucket sharing the minimum of image URLs, wherever they are
+// the tolerances still apply, since an agency can use the same photos for the units of a building
+func groupSharedImages(dedup *config.Dedup, properties []model.Property, bucket []int, groups duplicateGroups) {
+	byImage := map[string][]int{}
+	for _, i := range bucket {
+		for _, image := range properties[i].Images {
+			byImage[image] = append(byImage[image], i)
+		}
+	}
+	shared := map[[2]int]int{}
+	for _, positions := range byImage {
+		if len(positions) > maxImageListings {
+			continue
+		}
+		for a, i := range positions {
+			for _, j := range positions[a+1:] {
+				if i != j {
+					shared[[2]int{i, j}]++
+				}
+			}
+		}
+	}
+	for pair, count := range shared {
+		if count >= dedup.MinSharedImages && similarListing(dedup, &properties[pair[0]], &properties[pair[1]]) {
+			groups.union(pair[0], pair[1])
+		}
+	}
+}
+
+// similarListing tells if the usable areas and the prices of the Properties are inside the tolerances
+func similarListing(dedup *config.Dedup, first, second *model.Property) bool {
+	firstPrice, firstOk := parsePrice(first.PricingInfos.Price)
+	secondPrice, secondOk := parsePrice(second.PricingInfos.Price)
+	return firstOk && secondOk &&
+		withinTolerance(float64(first.UsableAreas), float64(second.UsableAreas), dedup.AreaTolerance) &&
+		withinTolerance(firstPrice, secondPrice, dedup.PriceTolerance)
+}
+
+// withinTolerance tells if the difference of the values is at most the tolerance of the largest one
+func withinTolerance(first, second, tolerance float64) bool {
+	return math.Abs(first-second) <= tolerance*math.Max(math.Abs(first), math.Abs(second))
+}
+
+// duplicateGroupID is the same while the group has the same Properties, whatever their order in the feed
+func duplicateGroupID(ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/tracing"
+	"gitlab.com/zap-api/config"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SnapshotVersionHeader tells the version of the snapshot exported, the start of the changes of the source
+const SnapshotVersionHeader = "Snapshot-Version"
+
+// exportFlushEvery is how many Properties are written between the flushes of the stream
+const exportFlushEvery = 100
+
+// exportContentTypes of the formats of the export
+var exportContentTypes = map[string]string{
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json",
+}
+
+// Export streams every Property of the source accepted by the filters, as NDJSON, CSV or a JSON array
+func Export(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	export(config, w, r, reflect.TypeOf(model.Property{}), propertyV1)
+}
+
+// ExportV2 streams every Property of the source accepted by the filters, with the typed money fields
+func ExportV2(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	export(config, w, r, reflect.TypeOf(model.PropertyV2{}), propertyV2)
+}
+
+// propertyV1 and propertyV2 convert the Properties of the snapshot to the models of the versions
+func propertyV1(property *model.Property) interface{} {
+	return property
+}
+
+func propertyV2(property *model.Property) interface{} {
+	v2 := toPropertyV2(property)
+	return &v2
+}
+
+// export writes the Properties straight from the snapshot, flushing the stream on every exportFlushEvery
+func export(config *config.Config, w http.ResponseWriter, r *http.Request, item reflect.Type, convert func(*model.Property) interface{}) {
+	source := mux.Vars(r)["source"]
+	logger := RequestLogger(config, r).WithField("source", source)
+	if !config.Enabled(source) {
+		logger.Error("No property found for the source")
+		respondError(w, http.StatusNotFound, "Source not accepted.")
+		return
+	}
+	if !sourceAllowed(r, source) {
+		logger.Error("Source not allowed for the client")
+		respondError(w, http.StatusForbidden, "Source not allowed.")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	var requested []string
+	if value := r.URL.Query().Get("columns"); value != "" {
+		requested = strings.Split(value, ",")
+	}
+	columns, invalidColumn := exportColumns(config, requested, item)
+	if invalidColumn != "" {
+		logger.WithField("column", invalidColumn).Error("Column not accepted")
+		respondError(w, http.StatusBadRequest, "Column not accepted: "+invalidColumn+".")
+		return
+	}
+	propertiesSnapshot := getSnapshotOr404(config, source, w, r)
+	if propertiesSnapshot == nil {
+		return
+	}
+	properties := propertiesSnapshot.selection(parseFilters(r.URL.Query()).apply(propertiesSnapshot))
+	logger.WithField("properties", len(properties)).WithField("format", format).Info("Exporting Properties")
+	_, span := tracing.Start(r.Context(), "export")
+	span.SetAttributes(attribute.String("format", format), attribute.Int("properties", len(properties)))
+	defer span.End()
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set(SnapshotVersionHeader, strconv.FormatInt(propertiesSnapshot.Version, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, source, format))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	exporter := newExporter(format, w, columns)
+	err := exporter.begin()
+	for i := 0; err == nil && i < len(properties); i++ {
+		if err = exporter.write(convert(&properties[i])); err == nil && flusher != nil && (i+1)%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err == nil {
+		err = exporter.end()
+	}
+	// The status was sent already, the client sees the stream cut
+	if err != nil {
+		logger.WithError(err).Error("Export interrupted")
+		tracing.SetError(span, err)
+	}
+}
+
+// exportColumns returns the columns requested, or the configured ones, and the first of them not found in the item
+func exportColumns(config *config.Config, requested []string, item reflect.Type) ([]string, string) {
+	columns := config.Export.Columns
+	if len(requested) > 0 {
+		columns = requested
+	}
+	for _, column := range columns {
+		if !hasColumn(item, column) {
+			return nil, column
+		}
+	}
+	return columns, ""
+}
+
+// exporter writes the Properties in a format, one by one
+type exporter interface {
+	begin() error
+	write(item interface{}) error
+	end() error
+}
+
+func newExporter(format string, w io.Writer, columns []string) exporter {
+	switch format {
+	case "csv":
+		return &csvExporter{writer: csv.NewWriter(w), columns: columns}
+	case "json":
+		return &jsonExporter{w: w}
+	case "geojson":
+		return &geojsonExporter{w: w}
+	}
+	return &ndjsonExporter{encoder: json.NewEncoder(w)}
+}
+
+// ndjsonExporter writes a JSON document by line
+type ndjsonExporter struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExporter) begin() error { return nil }
+
+func (e *ndjsonExporter) write(item interface{}) error { return e.encoder.Encode(item) }
+
+func (e *ndjsonExporter) end() error { return nil }
+
+// jsonExporter writes a JSON array, without keeping it in memory
+type jsonExporter struct {
+	w       io.Writer
+	written bool
+}
+
+func (e *jsonExporter) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonExporter) write(item interface{}) error {
+	if e.written {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.written = true
+	return json.NewEncoder(e.w).Encode(item)
+}
+
+func (e *jsonExporter) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// geojsonExporter writes a FeatureCollection, every Property is a Point of its location
+type geojsonExporter struct {
+	w       io.Writer
+	written bool
+}
+
+type geojsonFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geojsonGeometry `json:"geometry"`
+	Properties interface{}     `json:"properties"`
+}
+
+type geojsonGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func (e *geojsonExporter) begin() error {
+	_, err := io.WriteString(e.w, `{"type":"FeatureCollection","features":[`)
+	return err
+}
+
+func (e *geojsonExporter) write(item interface{}) error {
+	var location model.Location
+	switch property := item.(type) {
+	case *model.Property:
+		location = property.Address.GeoLocation.Location
+	case *model.PropertyV2:
+		location = property.Address.GeoLocation.Location
+	}
+	if e.written {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.written = true
+	return json.NewEncoder(e.w).Encode(&geojsonFeature{
+		Type:       "Feature",
+		Geometry:   geojsonGeometry{Type: "Point", Coordinates: [2]float64{location.Lon, location.Lat}},
+		Properties: item,
+	})
+}
+
+func (e *geojsonExporter) end() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+// csvExporter flattens the nested fields in the columns named with dots, like "address.city"
+// the fields of the columns are resolved once, on the first item, and the writer is flushed at the end
+type csvExporter struct {
+	writer  *csv.Writer
+	columns []string
+	fields  []columnField
+}
+
+func (e *csvExporter) begin() error {
+	return e.writer.Write(e.columns)
+}
+
+func (e *csvExporter) write(item interface{}) error {
+	value := reflect.ValueOf(item)
+	if e.fields == nil {
+		e.fields = make([]columnField, len(e.columns))
+		for i, column := range e.columns {
+			e.fields[i], _ = resolveColumn(value.Type(), column)
+		}
+	}
+	record := make([]string, len(e.columns))
+	for i, field := range e.fields {
+		record[i] = field.text(value)
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExporter) end() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// columnField is the index of the fields of a column in the nested structs, the last one is omitted when empty like in the JSON
+type columnField struct {
+	index     []int
+	omitEmpty bool
+}
+
+// resolveColumn finds the fields of the JSON path of the column in the item, it is false when the path is not found
+func resolveColumn(item reflect.Type, column string) (columnField, bool) {
+	field := columnField{}
+	for _, name := range strings.Split(column, ".") {
+		for item.Kind() == reflect.Ptr {
+			item = item.Elem()
+		}
+		if item.Kind() != reflect.Struct {
+			return field, false
+		}
+		found := false
+		for i := 0; i < item.NumField(); i++ {
+			tag := strings.Split(item.Field(i).Tag.Get("json"), ",")
+			if tag[0] == name {
+				field.index = append(field.index, i)
+				field.omitEmpty = len(tag) > 1 && tag[1] == "omitempty"
+				item, found = item.Field(i).Type, true
+				break
+			}
+		}
+		if !found {
+			return field, false
+		}
+	}
+	return field, true
+}
+
+// text of the field in the item, the Money is written as a decimal, like 540000.00, and the arrays and objects as JSON
+// the fields under a nil pointer, the nil ones and the ones omitted when empty are written empty
+func (f columnField) text(item reflect.Value) string {
+	value := item
+	for _, i := range f.index {
+		if value = reflect.Indirect(value); !value.IsValid() {
+			return ""
+		}
+		value = value.Field(i)
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	case reflect.Slice, reflect.Map:
+		if value.IsNil() || f.omitEmpty && value.Len() == 0 {
+			return ""
+		}
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		if f.omitEmpty && value.IsZero() {
+			return ""
+		}
+	}
+	if money, ok := value.Interface().(model.Money); ok && money.Currency == model.Currency {
+		return formatCents(money.Amount)
+	}
+	compact, _ := json.Marshal(value.Interface())
+	return string(compact)
+}
+
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// hasColumn tells if the column is a JSON path of the fields of the item
+func hasColumn(item reflect.Type, column string) bool {
+	_, found := resolveColumn(item, column)
+	return found
+}
@@ -0,0 +1,453 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/tracing"
+	"gitlab.com/zap-api/config"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Status of the export jobs, the last three are final
+const (
+	exportQueued    = "queued"
+	exportRunning   = "running"
+	exportSucceeded = "succeeded"
+	exportFailed    = "failed"
+	exportCancelled = "cancelled"
+)
+
+// exportRetryAfter is the wait suggested to the clients when the queue of the exports is full
+const exportRetryAfter = 30 * time.Second
+
+// exportJobFormats are the formats of the artifacts, they are always compressed with gzip
+var exportJobFormats = map[string]bool{"ndjson": true, "csv": true, "geojson": true}
+
+// exportJob runs in the background over the snapshot of its creation, its artifact is written in the path
+type exportJob struct {
+	model.ExportJob
+	client   string
+	path     string
+	download string
+	expires  time.Time
+	config   *config.Config
+	cancel   context.CancelFunc
+	run      func(ctx context.Context) (int, int64, error)
+}
+
+// exportQueue keeps the jobs in memory, they are started in the order of creation up to the concurrency limit
+// the jobs run with the context of the queue, it is cancelled by StopExports on the shutdown
+type exportQueue struct {
+	mutex   sync.Mutex
+	jobs    map[string]*exportJob
+	queue   []*exportJob
+	running int
+	swept   time.Time
+	ctx     context.Context
+	stopAll context.CancelFunc
+	done    sync.WaitGroup
+}
+
+var exports = newExportQueue()
+
+func newExportQueue() *exportQueue {
+	q := &exportQueue{jobs: map[string]*exportJob{}}
+	q.ctx, q.stopAll = context.WithCancel(context.Background())
+	return q
+}
+
+// add queues the job, it starts right away when the limit allows, and it is false when the queue is full
+func (q *exportQueue) add(job *exportJob) (model.ExportJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.sweep(job.config, time.Now())
+	if len(q.queue) >= job.config.Export.MaxQueued {
+		return job.ExportJob, false
+	}
+	q.jobs[job.Id] = job
+	q.queue = append(q.queue, job)
+	q.schedule()
+	return job.ExportJob, true
+}
+
+// schedule starts the jobs of the queue while there are less running than the limit of the configuration of the job
+func (q *exportQueue) schedule() {
+	for len(q.queue) > 0 && q.running < q.queue[0].config.Export.MaxConcurrent {
+		job := q.queue[0]
+		q.queue = q.queue[1:]
+		q.running++
+		q.done.Add(1)
+		ctx, cancel := context.WithCancel(q.ctx)
+		job.cancel = cancel
+		job.Status = exportRunning
+		job.StartedAt = time.Now().UTC().Format(time.RFC3339)
+		go func() {
+			defer q.done.Done()
+			properties, size, err := job.run(ctx)
+			q.finish(job, properties, size, err)
+		}()
+	}
+}
+
+// finish records the result of the job and starts the next ones, the artifact of a job cancelled is removed
+func (q *exportQueue) finish(job *exportJob, properties int, size int64, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.running--
+	job.cancel()
+	logger := job.config.Logger.WithField("job", "export").WithField("exportId", job.Id)
+	now := time.Now()
+	job.FinishedAt = now.UTC().Format(time.RFC3339)
+	job.expires = now.Add(job.config.Export.Retention)
+	job.ExpiresAt = job.expires.UTC().Format(time.RFC3339)
+	switch {
+	case job.Status == exportCancelled:
+		os.Remove(job.path)
+		logger.Info("Export cancelled")
+	case err != nil:
+		job.Status = exportFailed
+		job.Error = err.Error()
+		logger.WithError(err).Error("Export failed")
+	default:
+		job.Status = exportSucceeded
+		job.Properties = properties
+		job.Size = size
+		job.Download = job.download
+		logger.WithField("properties", properties).WithField("size", size).Info("Export finished")
+	}
+	q.schedule()
+}
+
+// get returns the job of the client, nil when it is not found
+func (q *exportQueue) get(config *config.Config, r *http.Request, id string) *exportJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.sweep(config, time.Now())
+	job, ok := q.jobs[id]
+	if !ok || job.client != clientName(r) {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+// stop cancels the job queued or running, it returns false when the job is already finished
+func (q *exportQueue) stop(config *config.Config, r *http.Request, id string) (*exportJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.sweep(config, time.Now())
+	job, ok := q.jobs[id]
+	if !ok || job.client != clientName(r) {
+		return nil, false
+	}
+	switch job.Status {
+	case exportQueued:
+		for i := range q.queue {
+			if q.queue[i] == job {
+				q.queue = append(q.queue[:i], q.queue[i+1:]...)
+				break
+			}
+		}
+		job.cancelQueued(time.Now())
+	case exportRunning:
+		job.Status = exportCancelled
+		job.cancel()
+	default:
+		copied := *job
+		return &copied, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// cancelQueued finishes the job cancelled before it started
+func (job *exportJob) cancelQueued(now time.Time) {
+	job.Status = exportCancelled
+	job.FinishedAt = now.UTC().Format(time.RFC3339)
+	job.expires = now.Add(job.config.Export.Retention)
+	job.ExpiresAt = job.expires.UTC().Format(time.RFC3339)
+}
+
+// StopExports cancels the export jobs queued and running, and waits for the running ones until the context is done
+// the jobs created after run with a new context, like the ones of a server started again
+func StopExports(ctx context.Context) error {
+	exports.mutex.Lock()
+	now := time.Now()
+	for _, job := range exports.queue {
+		job.cancelQueued(now)
+	}
+	exports.queue = nil
+	for _, job := range exports.jobs {
+		if job.Status == exportRunning {
+			job.Status = exportCancelled
+		}
+	}
+	exports.stopAll()
+	exports.ctx, exports.stopAll = context.WithCancel(context.Background())
+	exports.mutex.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		exports.done.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// exportSweepInterval is how often the directory is checked for the artifacts of the jobs of a previous run
+const exportSweepInterval = time.Minute
+
+// sweep removes the jobs expired with their artifacts, and the artifacts older than the retention without a job
+func (q *exportQueue) sweep(config *config.Config, now time.Time) {
+	for id, job := range q.jobs {
+		if !job.expires.IsZero() && now.After(job.expires) {
+			os.Remove(job.path)
+			delete(q.jobs, id)
+		}
+	}
+	if now.Sub(q.swept) < exportSweepInterval {
+		return
+	}
+	q.swept = now
+	files, _ := ioutil.ReadDir(config.Export.Directory)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "export-") {
+			continue
+		}
+		id := strings.SplitN(strings.TrimPrefix(file.Name(), "export-"), ".", 2)[0]
+		if _, ok := q.jobs[id]; !ok && now.Sub(file.ModTime()) > config.Export.Retention {
+			os.Remove(filepath.Join(config.Export.Directory, file.Name()))
+		}
+	}
+}
+
+func clientName(r *http.Request) string {
+	if client := ClientOf(r); client != nil {
+		return client.Name
+	}
+	return ""
+}
+
+// CreateExport starts a job writing the Properties of the source to a gzip artifact
+func CreateExport(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	createExport(config, w, r, reflect.TypeOf(model.Property{}), propertyV1)
+}
+
+// CreateExportV2 starts a job writing the Properties of the source with the typed money fields
+func CreateExportV2(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	createExport(config, w, r, reflect.TypeOf(model.PropertyV2{}), propertyV2)
+}
+
+// createExport validates the request and queues the job over the current snapshot of the source
+func createExport(config *config.Config, w http.ResponseWriter, r *http.Request, item reflect.Type, convert func(*model.Property) interface{}) {
+	logger := RequestLogger(config, r)
+	request := model.ExportRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logger.WithError(err).Error("Invalid body for the export")
+		respondError(w, http.StatusBadRequest, "Invalid body.")
+		return
+	}
+	logger = logger.WithField("source", request.Source)
+	if !config.Enabled(request.Source) {
+		logger.Error("Source of the export not accepted")
+		respondError(w, http.StatusBadRequest, "Source not accepted.")
+		return
+	}
+	if !sourceAllowed(r, request.Source) {
+		logger.Error("Source not allowed for the client")
+		respondError(w, http.StatusForbidden, "Source not allowed.")
+		return
+	}
+	if request.Format == "" {
+		request.Format = "ndjson"
+	}
+	if !exportJobFormats[request.Format] {
+		logger.WithField("format", request.Format).Error("Format not accepted")
+		respondError(w, http.StatusBadRequest, "Format not accepted: "+request.Format+".")
+		return
+	}
+	var columns []string
+	if request.Format == "csv" {
+		var invalidColumn string
+		if columns, invalidColumn = exportColumns(config, request.Columns, item); invalidColumn != "" {
+			logger.WithField("column", invalidColumn).Error("Column not accepted")
+			respondError(w, http.StatusBadRequest, "Column not accepted: "+invalidColumn+".")
+			return
+		}
+	}
+	if invalidFilter := validateFilters(request.Filters); invalidFilter != "" {
+		logger.WithField("filter", invalidFilter).Error("Filter not accepted")
+		respondError(w, http.StatusBadRequest, "Filter not accepted: "+invalidFilter+".")
+		return
+	}
+	propertiesSnapshot := getSnapshotOr404(config, request.Source, w, r)
+	if propertiesSnapshot == nil {
+		return
+	}
+	filter := parseFilters(filterQuery(request.Filters))
+	id := newRequestID()
+	prefix := strings.TrimSuffix(r.URL.Path, "/exports")
+	job := &exportJob{
+		ExportJob: model.ExportJob{
+			Id:              id,
+			Status:          exportQueued,
+			Source:          request.Source,
+			Format:          request.Format,
+			Columns:         columns,
+			Filters:         request.Filters,
+			SnapshotVersion: propertiesSnapshot.Version,
+			CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+		},
+		client:   clientName(r),
+		path:     filepath.Join(config.Export.Directory, "export-"+id+"."+request.Format+".gz"),
+		download: prefix + "/exports/" + id + "/download",
+		config:   config,
+	}
+	job.run = func(ctx context.Context) (int, int64, error) {
+		ctx, span := tracing.Start(ctx, "export job")
+		defer span.End()
+		span.SetAttributes(attribute.String("source", job.Source), attribute.String("format", job.Format))
+		properties := propertiesSnapshot.selection(filter.apply(propertiesSnapshot))
+		size, err := writeArtifact(ctx, job.path, job.Format, columns, properties, convert)
+		if err != nil {
+			tracing.SetError(span, err)
+		}
+		return len(properties), size, err
+	}
+	created, queued := exports.add(job)
+	if !queued {
+		logger.WithField("maxQueued", config.Export.MaxQueued).Error("Export queue full")
+		w.Header().Set("Retry-After", ceilSeconds(exportRetryAfter))
+		respondError(w, http.StatusServiceUnavailable, "Export queue full.")
+		return
+	}
+	logger.WithField("exportId", id).WithField("format", request.Format).Info("Export queued")
+	w.Header().Set("Location", prefix+"/exports/"+id)
+	respondJSON(w, http.StatusAccepted, &created)
+}
+
+// writeArtifact writes the gzip of the Properties to a partial file, it is renamed to the path when complete
+func writeArtifact(ctx context.Context, path, format string, columns []string, properties []model.Property, convert func(*model.Property) interface{}) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	partial := path + ".part"
+	file, err := os.Create(partial)
+	if err != nil {
+		return 0, err
+	}
+	err = writeGzip(ctx, file, format, columns, properties, convert)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// writeGzip stops on the cancellation of the context, between two Properties
+func writeGzip(ctx context.Context, w io.Writer, format string, columns []string, properties []model.Property, convert func(*model.Property) interface{}) error {
+	buffered := bufio.NewWriter(w)
+	compressed := gzip.NewWriter(buffered)
+	exporter := newExporter(format, compressed, columns)
+	err := exporter.begin()
+	for i := 0; err == nil && i < len(properties); i++ {
+		if err = ctx.Err(); err == nil {
+			err = exporter.write(convert(&properties[i]))
+		}
+	}
+	if err == nil {
+		err = exporter.end()
+	}
+	if err == nil {
+		err = compressed.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	return err
+}
+
+// GetExport answers the status of the job
+func GetExport(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	job := exports.get(config, r, mux.Vars(r)["id"])
+	if job == nil {
+		RequestLogger(config, r).WithField("exportId", mux.Vars(r)["id"]).Error("Export not found")
+		respondError(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	respondJSON(w, http.StatusOK, &job.ExportJob)
+}
+
+// CancelExport cancels the job queued or running, its partial artifact is removed
+func CancelExport(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	logger := RequestLogger(config, r).WithField("exportId", mux.Vars(r)["id"])
+	job, cancelled := exports.stop(config, r, mux.Vars(r)["id"])
+	if job == nil {
+		logger.Error("Export not found")
+		respondError(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	if !cancelled {
+		logger.WithField("status", job.Status).Error("Export already finished")
+		respondError(w, http.StatusConflict, "Export already finished.")
+		return
+	}
+	logger.Info("Export cancelled")
+	respondJSON(w, http.StatusOK, &job.ExportJob)
+}
+
+// DownloadExport serves the artifact of the job succeeded, with the ranges and the conditional requests
+func DownloadExport(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	logger := RequestLogger(config, r).WithField("exportId", mux.Vars(r)["id"])
+	job := exports.get(config, r, mux.Vars(r)["id"])
+	if job == nil {
+		logger.Error("Export not found")
+		respondError(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	if job.Status != exportSucceeded {
+		logger.WithField("status", job.Status).Error("Export not available")
+		respondError(w, http.StatusConflict, "Export "+job.Status+".")
+		return
+	}
+	file, err := os.Open(job.path)
+	if err != nil {
+		logger.WithError(err).Error("Artifact of the export not found")
+		respondError(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	defer file.Close()
+	finishedAt, _ := time.Parse(time.RFC3339, job.FinishedAt)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s.gz"`, job.Source, job.Id, job.Format))
+	http.ServeContent(w, r, "", finishedAt, file)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+
+	"gitlab.com/zap-api/app/model"
+)
+
+// parseFacets reads the comma separated facets parameter and returns the first facet not accepted, if any
+func parseFacets(param string) ([]string, string) {
+	if param == "" {
+		return nil, ""
+	}
+	names := []string{}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := facetFields[name]; !ok {
+			return nil, name
+		}
+		names = append(names, name)
+	}
+	return names, ""
+}
+
+// countFacets counts the selected Properties in every bucket of the requested facets
+// the counts come straight from the index, so the Properties themselves are never visited
+func countFacets(s *snapshot, selected bitset, names []string) map[string][]model.FacetCount {
+	facets := make(map[string][]model.FacetCount, len(names))
+	for _, name := range names {
+		counts := []model.FacetCount{}
+		for value, positions := range s.index.facets[name] {
+			if count := selected.countAnd(positions); count > 0 {
+				counts = append(counts, model.FacetCount{Value: value, Count: count})
+			}
+		}
+		// Biggest buckets first, the value keeps the order stable between requests
+		sort.Slice(counts, func(i, j int) bool {
+			if counts[i].Count != counts[j].Count {
+				return counts[i].Count > counts[j].Count
+			}
+			return counts[i].Value < counts[j].Value
+		})
+		facets[name] = counts
+	}
+	return facets
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// filterParams are the query parameters that select Properties through the index
+var filterParams = []string{"bedrooms", "bathrooms", "neighborhood", "businessType", "priceRange"}
+
+// storedFilters are the query parameters of the /properties that the exports and the searches keep as their filters
+var storedFilters = append([]string{"q", "minPrice", "maxPrice", "priceDropped", "priceChangedSince", "collapseDuplicates", "anomalies"}, filterParams...)
+
+// validateFilters returns the first filter not accepted, by its name or by validateFilter
+func validateFilters(filters map[string]string) string {
+	for name, value := range filters {
+		if !containsString(storedFilters, name) || validateFilter(name, value) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// validateFilter checks the value of the filter, the prices must be numbers, priceChangedSince a time
+// and priceDropped, collapseDuplicates and anomalies booleans
+func validateFilter(name, value string) error {
+	switch name {
+	case "minPrice", "maxPrice":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("parameter %s must be a number", name)
+		}
+	case "priceDropped", "collapseDuplicates", "anomalies":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("parameter %s must be a boolean", name)
+		}
+	case "priceChangedSince":
+		if _, ok := parseSince(value); !ok {
+			return fmt.Errorf("parameter %s must be a time of RFC 3339 or a date", name)
+		}
+	}
+	return nil
+}
+
+// parseSince reads a time in RFC 3339, or just a date at the midnight in UTC
+func parseSince(value string) (time.Time, bool) {
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, true
+	}
+	if since, err := time.Parse("2006-01-02", value); err == nil {
+		return since, true
+	}
+	return time.Time{}, false
+}
+
+// filterQuery returns the filters kept as the query of a request
+func filterQuery(filters map[string]string) url.Values {
+	query := url.Values{}
+	for name, value := range filters {
+		query.Set(name, value)
+	}
+	return query
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// propertyFilter has the filters requested in the query string
+type propertyFilter struct {
+	fields   map[string]string
+	query    string
+	minPrice float64
+	maxPrice float64
+	// priceDropped is nil when the Properties are not filtered by their last price change
+	priceDropped      *bool
+	priceChangedSince time.Time
+	// collapseDuplicates keeps just the first Property of every group of probable duplicates
+	collapseDuplicates bool
+	// anomalies is nil when the Properties are not filtered by their anomalies
+	anomalies *bool
+}
+
+// parseFilters reads the filters from the query, invalid values are ignored like the pagination parameters
+func parseFilters(query url.Values) *propertyFilter {
+	filter := &propertyFilter{fields: map[string]string{}, query: query.Get("q")}
+	for _, name := range filterParams {
+		if value := query.Get(name); value != "" {
+			filter.fields[name] = value
+		}
+	}
+	if minPrice, err := strconv.ParseFloat(query.Get("minPrice"), 64); err == nil {
+		filter.minPrice = minPrice
+	}
+	if maxPrice, err := strconv.ParseFloat(query.Get("maxPrice"), 64); err == nil {
+		filter.maxPrice = maxPrice
+	}
+	if priceDropped, err := strconv.ParseBool(query.Get("priceDropped")); err == nil {
+		filter.priceDropped = &priceDropped
+	}
+	if since, ok := parseSince(query.Get("priceChangedSince")); ok {
+		filter.priceChangedSince = since
+	}
+	filter.collapseDuplicates, _ = strconv.ParseBool(query.Get("collapseDuplicates"))
+	if anomalies, err := strconv.ParseBool(query.Get("anomalies")); err == nil {
+		filter.anomalies = &anomalies
+	}
+	return filter
+}
+
+// apply returns the positions of the Properties in the snapshot that match every filter
+func (filter *propertyFilter) apply(s *snapshot) bitset {
+	selected := fullBitset(len(s.Properties))
+	for name, value := range filter.fields {
+		selected.and(s.index.lookup(name, value))
+	}
+	if filter.query != "" {
+		selected.and(s.text.search(filter.query))
+	}
+	if filter.minPrice != 0 || filter.maxPrice != 0 || filter.priceDropped != nil || !filter.priceChangedSince.IsZero() {
+		filter.applyPrices(s, selected)
+	}
+	if filter.anomalies != nil {
+		applyAnomalies(s, selected, *filter.anomalies)
+	}
+	if filter.collapseDuplicates {
+		collapseDuplicates(s, selected)
+	}
+	return selected
+}
+
+// applyPrices keeps in selected the Properties inside the price limits, with the price change requested
+func (filter *propertyFilter) applyPrices(s *snapshot, selected bitset) {
+	for i := range s.Properties {
+		if !selected.has(i) {
+			continue
+		}
+		if filter.priceDropped != nil && s.priceDropped.has(i) != *filter.priceDropped {
+			selected.clear(i)
+			continue
+		}
+		// The Properties without a price change are never changed since any time
+		if !filter.priceChangedSince.IsZero() &&
+			(s.priceChangedAt[i].IsZero() || s.priceChangedAt[i].Before(filter.priceChangedSince)) {
+			selected.clear(i)
+			continue
+		}
+		if filter.minPrice == 0 && filter.maxPrice == 0 {
+			continue
+		}
+		price, ok := parsePrice(s.Properties[i].PricingInfos.Price)
+		if !ok || price < filter.minPrice || (filter.maxPrice > 0 && price > filter.maxPrice) {
+			selected.clear(i)
+		}
+	}
+}
+
+// applyAnomalies keeps in selected the Properties with anomalies, or the ones without them
+func applyAnomalies(s *snapshot, selected bitset, anomalies bool) {
+	for i := range s.Properties {
+		if selected.has(i) && (len(s.Properties[i].Anomalies) > 0) != anomalies {
+			selected.clear(i)
+		}
+	}
+}
+
+// collapseDuplicates keeps in selected just the first Property of every group of probable duplicates, in the snapshot order
+func collapseDuplicates(s *snapshot, selected bitset) {
+	seen := map[string]bool{}
+	for i := range s.Properties {
+		group := s.Properties[i].DuplicateGroupId
+		if !selected.has(i) || group == "" {
+			continue
+		}
+		if seen[group] {
+			selected.clear(i)
+		}
+		seen[group] = true
+	}
+}
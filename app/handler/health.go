@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// GetHealth responds the liveness, the API is alive while it is answering
+func GetHealth(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GetReadiness responds 200 when every source has a snapshot newer than the max age, or 503 otherwise
+func GetReadiness(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	readiness := model.Readiness{Ready: true, Sources: map[string]model.SourceStatus{}}
+	for _, source := range config.SortedDatasources() {
+		status := sourceStatus(config, source)
+		readiness.Ready = readiness.Ready && status.Ready
+		readiness.Sources[source] = status
+	}
+	if !readiness.Ready {
+		respondJSON(w, http.StatusServiceUnavailable, &readiness)
+		return
+	}
+	respondJSON(w, http.StatusOK, &readiness)
+}
+
+func sourceStatus(config *config.Config, source string) model.SourceStatus {
+	status := model.SourceStatus{Status: "loading"}
+	if propertiesCached, found := config.Cache.Get(source); found {
+		propertiesSnapshot := propertiesCached.(*snapshot)
+		age := time.Since(propertiesSnapshot.CreatedAt)
+		status.SnapshotVersion = propertiesSnapshot.Version
+		status.LoadedAt = propertiesSnapshot.CreatedAt.UTC().Format(time.RFC3339)
+		status.AgeSeconds = age.Seconds()
+		status.Properties = len(propertiesSnapshot.Properties)
+		status.Status = "ready"
+		status.Ready = true
+		if age > config.Ingestion.MaxAge {
+			status.Status = "stale"
+			status.Ready = false
+		}
+	}
+	if err, ok := ingestionErrors.get(source); ok {
+		status.LastError = err.message
+		status.LastErrorAt = err.at.UTC().Format(time.RFC3339)
+	}
+	return status
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"math/bits"
+	"strconv"
+
+	"gitlab.com/zap-api/app/model"
+)
+
+// bitset marks the positions of the Properties inside a snapshot, one bit per Property
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+// fullBitset returns a bitset with every position up to size already set
+func fullBitset(size int) bitset {
+	b := newBitset(size)
+	for i := 0; i < size; i++ {
+		b.set(i)
+	}
+	return b
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b bitset) clear(i int) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+func (b bitset) has(i int) bool {
+	return b[i/64]&(1<<uint(i%64)) != 0
+}
+
+// and keeps in b only the positions that are also set in other
+func (b bitset) and(other bitset) {
+	for i := range b {
+		if i < len(other) {
+			b[i] &= other[i]
+		} else {
+			b[i] = 0
+		}
+	}
+}
+
+// count returns how many positions are set in b
+func (b bitset) count() int {
+	total := 0
+	for _, word := range b {
+		total += bits.OnesCount64(word)
+	}
+	return total
+}
+
+// countAnd returns how many positions are set in both b and other, without allocating
+func (b bitset) countAnd(other bitset) int {
+	total := 0
+	for i := range b {
+		if i < len(other) {
+			total += bits.OnesCount64(b[i] & other[i])
+		}
+	}
+	return total
+}
+
+// facetFields are the Property fields that can be filtered and counted by the index
+var facetFields = map[string]func(property *model.Property) string{
+	"bedrooms": func(property *model.Property) string {
+		return strconv.Itoa(property.Bedrooms)
+	},
+	"bathrooms": func(property *model.Property) string {
+		return strconv.Itoa(property.Bathrooms)
+	},
+	"neighborhood": func(property *model.Property) string {
+		return property.Address.Neighborhood
+	},
+	"businessType": func(property *model.Property) string {
+		return property.PricingInfos.BusinessType
+	},
+	"priceRange": priceRange,
+}
+
+// priceRanges are the upper limits of every bucket used by the priceRange facet for each businessType
+var priceRanges = map[string][]int{
+	"SALE":   {300000, 600000, 1000000, 2000000},
+	"RENTAL": {2000, 4000, 7000, 10000},
+}
+
+// priceRange returns the bucket of the Property price, like "600000-1000000" or "2000000+"
+func priceRange(property *model.Property) string {
+	price, ok := parsePrice(property.PricingInfos.Price)
+	limits, found := priceRanges[property.PricingInfos.BusinessType]
+	if !ok || !found {
+		return ""
+	}
+	lower := 0
+	for _, upper := range limits {
+		if price < float64(upper) {
+			return strconv.Itoa(lower) + "-" + strconv.Itoa(upper)
+		}
+		lower = upper
+	}
+	return strconv.Itoa(lower) + "+"
+}
+
+// parsePrice reads the Price as a float, since after the campaigns it is no longer an integer
+func parsePrice(price string) (float64, bool) {
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// propertyIndex keeps, for every facet field and value, the positions of the Properties that have it
+type propertyIndex struct {
+	size   int
+	facets map[string]map[string]bitset
+}
+
+func newPropertyIndex(properties []model.Property) *propertyIndex {
+	index := &propertyIndex{
+		size:   len(properties),
+		facets: make(map[string]map[string]bitset, len(facetFields)),
+	}
+	for name := range facetFields {
+		index.facets[name] = map[string]bitset{}
+	}
+	for i := range properties {
+		for name, field := range facetFields {
+			value := field(&properties[i])
+			if value == "" {
+				continue
+			}
+			positions, ok := index.facets[name][value]
+			if !ok {
+				positions = newBitset(index.size)
+				index.facets[name][value] = positions
+			}
+			positions.set(i)
+		}
+	}
+	return index
+}
+
+// lookup returns the positions of the Properties with the value for the facet field
+func (index *propertyIndex) lookup(name, value string) bitset {
+	if positions, ok := index.facets[name][value]; ok {
+		return positions
+	}
+	return newBitset(index.size)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/tracing"
+	"gitlab.com/zap-api/config"
+)
+
+// ingestionError is the last error of the ingestion of a source
+type ingestionError struct {
+	message string
+	at      time.Time
+}
+
+// ingestionErrorLog keeps the last error of every source, they are shown by the readiness
+type ingestionErrorLog struct {
+	mutex  sync.Mutex
+	errors map[string]ingestionError
+}
+
+var ingestionErrors = &ingestionErrorLog{errors: map[string]ingestionError{}}
+
+// record keeps the error for every source, since all of them come from the same upstream
+func (l *ingestionErrorLog) record(config *config.Config, message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, source := range config.SortedDatasources() {
+		l.errors[source] = ingestionError{message: message, at: time.Now()}
+	}
+}
+
+func (l *ingestionErrorLog) get(source string) (ingestionError, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err, ok := l.errors[source]
+	return err, ok
+}
+
+// rawFeed keeps the last Properties downloaded from the upstream, before the rules of the sources,
+// so a reload of the configuration evaluates them again without downloading the feed
+// the fetchedAt is the start of the request, a feed requested before the one kept is not kept
+type rawFeed struct {
+	mutex      sync.Mutex
+	properties *[]model.Property
+	fetchedAt  time.Time
+}
+
+var lastFeed = &rawFeed{}
+
+func (f *rawFeed) set(properties *[]model.Property, fetchedAt time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if fetchedAt.Before(f.fetchedAt) {
+		return
+	}
+	f.properties = properties
+	f.fetchedAt = fetchedAt
+}
+
+func (f *rawFeed) get() (*[]model.Property, time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.properties, f.fetchedAt
+}
+
+// Ingest requests the Properties from the upstream and sets the snapshots of every source in the cache
+func Ingest(ctx context.Context, config *config.Config) error {
+	logger := config.Logger.WithField("job", "ingestion")
+	ctx, span := tracing.Start(ctx, "ingestion")
+	defer span.End()
+	logger.WithField("url", config.Endpoints.ZapProperties).Info("Requesting data from ZAP")
+	fetchedAt := time.Now()
+	properties := []model.Property{}
+	if err := requestProperties(ctx, &properties, config.Endpoints.ZapProperties); err != nil {
+		logger.WithError(err).Error("Ingestion failed")
+		tracing.SetError(span, err)
+		ingestionErrors.record(config, err.Error())
+		return err
+	}
+	lastFeed.set(&properties, fetchedAt)
+	setCacheProperties(ctx, "", &properties, fetchedAt, config, logger)
+	return nil
+}
+
+// Reevaluate applies the rules, zones and campaigns of the configuration to the last feed downloaded,
+// it returns false when there is no feed yet, then the next ingestion applies them
+func Reevaluate(ctx context.Context, config *config.Config) bool {
+	logger := config.Logger.WithField("job", "reevaluation")
+	properties, fetchedAt := lastFeed.get()
+	if properties == nil {
+		logger.Info("There is no feed to evaluate yet")
+		return false
+	}
+	ctx, span := tracing.Start(ctx, "reevaluation")
+	defer span.End()
+	logger.WithField("fetchedAt", fetchedAt.UTC().Format(time.RFC3339)).Info("Evaluating the last feed again")
+	setCacheProperties(ctx, "", properties, fetchedAt, config, logger)
+	return true
+}
+
+// RunIngestion ingests right away and then on every interval, so the requests never wait for the upstream
+// the configuration is taken on every ingestion, so the reloads change the rules and the interval
+func RunIngestion(ctx context.Context, current func() *config.Config) {
+	Ingest(ctx, current())
+	interval := current().Ingestion.Interval
+	ticker := time.NewTicker(interval)
+	defer func() { ticker.Stop() }()
+	for {
+		select {
+		case <-ticker.C:
+			Ingest(ctx, current())
+			if reloaded := current().Ingestion.Interval; reloaded != interval {
+				interval = reloaded
+				ticker.Stop()
+				ticker = time.NewTicker(interval)
+			}
+		case <-ctx.Done():
+			current().Logger.WithField("job", "ingestion").Info("Ingestion stopped")
+			return
+		}
+	}
+}
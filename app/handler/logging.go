@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gitlab.com/zap-api/config"
+)
+
+// RequestIDHeader is read from the clients and answered in every response
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey string
+
+const requestIDKey contextKey = "requestId"
+
+// sensitiveHeaders are never written in the logs
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// WithRequestID keeps the ID of the request in its context, an ID not informed or too long is replaced by a new one
+func WithRequestID(r *http.Request) (*http.Request, string) {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 128 || strings.ContainsAny(id, " \t\r\n") {
+		id = newRequestID()
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey, id)), id
+}
+
+// RequestID returns the ID kept by WithRequestID, or empty when the request has none
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// RequestLogger returns the Logger with the ID of the request, so every line of the request can be found
+// the client authenticated is logged too
+func RequestLogger(config *config.Config, r *http.Request) *logrus.Entry {
+	logger := config.Logger.WithField("requestId", RequestID(r))
+	if client := ClientOf(r); client != nil {
+		logger = logger.WithField("client", client.Name)
+	}
+	return logger
+}
+
+// RedactHeaders returns the headers to be logged, with the values of the sensitive ones hidden
+func RedactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			redacted[name] = "[REDACTED]"
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return redacted
+}
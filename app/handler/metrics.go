@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gitlab.com/zap-api/config"
+)
+
+// LatencyBuckets are the upper limits in seconds of the latency histograms
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+var (
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_cache_requests_total",
+		Help: "Lookups of the snapshots in the cache, by result hit or miss."}, []string{"source", "result"})
+	upstreamFetches = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_upstream_fetches_total",
+		Help: "Requests of the Properties to the upstream, by result success or error."}, []string{"result"})
+	upstreamDuration = promauto.NewHistogram(prometheus.HistogramOpts{Name: "zap_upstream_fetch_duration_seconds",
+		Help: "Duration of the requests of the Properties to the upstream, decoding included.", Buckets: LatencyBuckets})
+	upstreamBytes = promauto.NewCounter(prometheus.CounterOpts{Name: "zap_upstream_fetch_bytes_total",
+		Help: "Bytes read from the upstream."})
+	ingestionAccepted = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_ingestion_accepted_total",
+		Help: "Properties accepted by the rules of the source."}, []string{"source"})
+	ingestionRejected = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_ingestion_rejected_total",
+		Help: "Properties rejected, by source and rule."}, []string{"source", "rule"})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_rate_limited_total",
+		Help: "Requests rejected by the rate limits and quotas, by route."}, []string{"route"})
+	streamEvents = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_stream_events_total",
+		Help: "Events sent to the streams, by source and event."}, []string{"source", "event"})
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_webhook_deliveries_total",
+		Help: "Attempts of the webhooks of the saved searches, by result delivered, retried or dead."}, []string{"result"})
+)
+
+// datasetAge collects the age of the snapshots in the cache on every scrape, with the current configuration
+type datasetAge struct {
+	current func() *config.Config
+	desc    *prometheus.Desc
+}
+
+func (d *datasetAge) Describe(descs chan<- *prometheus.Desc) {
+	descs <- d.desc
+}
+
+func (d *datasetAge) Collect(metrics chan<- prometheus.Metric) {
+	config := d.current()
+	for _, source := range config.SortedDatasources() {
+		if propertiesCached, found := config.Cache.Get(source); found {
+			age := time.Since(propertiesCached.(*snapshot).CreatedAt).Seconds()
+			metrics <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, age, source)
+		}
+	}
+}
+
+// RegisterMetrics registers the metrics read from the cache on every scrape, replacing the ones of a previous configuration
+func RegisterMetrics(current func() *config.Config) {
+	collector := &datasetAge{current: current, desc: prometheus.NewDesc("zap_dataset_age_seconds",
+		"Age of the cached snapshot of the source.", []string{"source"}, nil)}
+	prometheus.Unregister(collector)
+	prometheus.MustRegister(collector)
+}
+
+// GetMetrics responds the metrics in the Prometheus text format, with the ones of the Go runtime and of the process
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+// rejectForEverySource counts a Property rejected before the rules of the sources
+func rejectForEverySource(config *config.Config, rule string) {
+	for _, source := range config.SortedDatasources() {
+		ingestionRejected.WithLabelValues(source, rule).Inc()
+	}
+}
+
+// countingReader counts the bytes read from the upstream
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"gitlab.com/zap-api/app/model"
+)
+
+// parseCents reads a decimal amount like "540000.000000" as cents, rounding half away from zero
+// it never goes through a float, so the same string is always the same amount
+func parseCents(amount string) (int64, bool) {
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	integer, fraction := amount, ""
+	if dot := strings.Index(amount, "."); dot >= 0 {
+		integer, fraction = amount[:dot], amount[dot+1:]
+	}
+	if integer == "" && fraction == "" {
+		return 0, false
+	}
+	for _, digit := range integer + fraction {
+		if digit < '0' || digit > '9' {
+			return 0, false
+		}
+	}
+	units := int64(0)
+	if integer != "" {
+		var err error
+		if units, err = strconv.ParseInt(integer, 10, 64); err != nil {
+			return 0, false
+		}
+	}
+	fraction += "000"
+	cents := units*100 + int64(fraction[0]-'0')*10 + int64(fraction[1]-'0')
+	if fraction[2] >= '5' {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return cents, true
+}
+
+// newMoney returns nil when the amount is empty or not a number
+func newMoney(amount string) *model.Money {
+	cents, ok := parseCents(amount)
+	if !ok {
+		return nil
+	}
+	return &model.Money{Amount: cents, Currency: model.Currency}
+}
+
+// divideRounded divides the cents rounding half away from zero, like parseCents
+func divideRounded(cents, divisor int64) int64 {
+	if cents < 0 {
+		return -divideRounded(-cents, divisor)
+	}
+	return (cents + divisor/2) / divisor
+}
+
+// toPropertyV2 converts the Property with the computed price per square meter and monthly cost
+// the monthly cost is the rent for RENTAL plus the condo fee and a twelfth of the IPTU
+func toPropertyV2(property *model.Property) model.PropertyV2 {
+	pricing := property.PricingInfos
+	v2 := model.PropertyV2{
+		UsableAreas:      property.UsableAreas,
+		ListingType:      property.ListingType,
+		CreatedAt:        property.CreatedAt,
+		ListingStatus:    property.ListingStatus,
+		Id:               property.Id,
+		ParkingSpaces:    property.ParkingSpaces,
+		UpdatedAt:        property.UpdatedAt,
+		Owner:            property.Owner,
+		Images:           property.Images,
+		Address:          property.Address,
+		Bathrooms:        property.Bathrooms,
+		Bedrooms:         property.Bedrooms,
+		DuplicateGroupId: property.DuplicateGroupId,
+		QualityScore:     property.QualityScore,
+		Anomalies:        property.Anomalies,
+		PricingInfos: model.PricingInfosV2{
+			YearlyIptu:       newMoney(pricing.YearlyIptu),
+			Price:            newMoney(pricing.Price),
+			BusinessType:     pricing.BusinessType,
+			MonthlyCondoFee:  newMoney(pricing.MonthlyCondoFee),
+			Period:           pricing.Period,
+			RentalTotalPrice: newMoney(pricing.RentalTotalPrice),
+		},
+	}
+	price := v2.PricingInfos.Price
+	if price != nil && property.UsableAreas > 0 {
+		v2.PricePerSquareMeter = &model.Money{
+			Amount:   divideRounded(price.Amount, int64(property.UsableAreas)),
+			Currency: model.Currency,
+		}
+	}
+	monthly := int64(0)
+	if pricing.BusinessType == "RENTAL" && price != nil {
+		monthly += price.Amount
+	}
+	if fee := v2.PricingInfos.MonthlyCondoFee; fee != nil {
+		monthly += fee.Amount
+	}
+	if iptu := v2.PricingInfos.YearlyIptu; iptu != nil {
+		monthly += divideRounded(iptu.Amount, 12)
+	}
+	v2.TotalMonthlyCost = &model.Money{Amount: monthly, Currency: model.Currency}
+	return v2
+}
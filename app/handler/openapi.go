@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/openapi"
+	"gitlab.com/zap-api/config"
+)
+
+// GetOpenAPI responds the OpenAPI document of the API
+func GetOpenAPI(document *openapi.Document, w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, document)
+}
+
+// ValidateRequest responds 400 and returns false when the query or header parameters do not match the OpenAPI document,
+// or the filters of the query are not read by their parsers, like the times of the priceChangedSince
+// the source header responds 404 like the handlers, the source not accepted is a resource not found
+func ValidateRequest(config *config.Config, document *openapi.Document, w http.ResponseWriter, r *http.Request) bool {
+	operation := currentOperation(document, r)
+	if operation == nil {
+		return true
+	}
+	query := r.URL.Query()
+	for _, parameter := range operation.Parameters {
+		var value string
+		switch parameter.In {
+		case "query":
+			value = query.Get(parameter.Name)
+		case "header":
+			value = r.Header.Get(parameter.Name)
+		default:
+			continue
+		}
+		if value == "" && !parameter.Required {
+			continue
+		}
+		var err error
+		if value == "" {
+			err = fmt.Errorf("parameter %s is required", parameter.Name)
+		} else if err = document.ValidateParameter(parameter, value); err == nil && parameter.In == "query" {
+			err = validateFilter(parameter.Name, value)
+		}
+		if err == nil {
+			continue
+		}
+		RequestLogger(config, r).WithField("parameter", parameter.Name).WithError(err).Error("Invalid parameter")
+		if parameter.In == "header" && parameter.Name == "source" {
+			respondError(w, http.StatusNotFound, "Source not accepted.")
+		} else {
+			respondError(w, http.StatusBadRequest, "Invalid request: "+err.Error()+".")
+		}
+		return false
+	}
+	return true
+}
+
+// currentOperation finds the Operation of the route matched by the router
+func currentOperation(document *openapi.Document, r *http.Request) *openapi.Operation {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	return document.Operation(path, r.Method)
+}
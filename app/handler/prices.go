@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// priceEntry has the prices observed for a Property, and the last time it was seen in a snapshot
+type priceEntry struct {
+	LastSeen string             `json:"lastSeen"`
+	Prices   []model.PricePoint `json:"prices"`
+}
+
+// lastChange returns the time of the last change of the adjusted price, and if it was a drop
+// it is the zero time while the Property has just one adjusted price
+func (e *priceEntry) lastChange() (time.Time, bool) {
+	for i := len(e.Prices) - 1; i > 0; i-- {
+		current, previous := e.Prices[i].AdjustedPrice.Amount, e.Prices[i-1].AdjustedPrice.Amount
+		if current != previous {
+			observedAt, _ := time.Parse(time.RFC3339, e.Prices[i].ObservedAt)
+			return observedAt, current < previous
+		}
+	}
+	return time.Time{}, false
+}
+
+// priceStore keeps the prices of the Properties of every source by their IDs, the changes are written to the file
+// the file is loaded again when the configuration points to another one
+type priceStore struct {
+	mutex   sync.Mutex
+	file    string
+	sources map[string]map[string]*priceEntry
+}
+
+var prices = &priceStore{}
+
+// open loads the file of the configuration, a missing file has no prices
+func (s *priceStore) open(config *config.Config) error {
+	if s.file == config.Prices.File && s.sources != nil {
+		return nil
+	}
+	sources := map[string]map[string]*priceEntry{}
+	data, err := ioutil.ReadFile(config.Prices.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &sources); err != nil {
+			return err
+		}
+	}
+	s.file = config.Prices.File
+	s.sources = sources
+	return nil
+}
+
+// save writes the file at once, through a temporary file renamed over it
+func (s *priceStore) save() error {
+	data, err := json.Marshal(s.sources)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.file+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(s.file+".tmp", s.file)
+}
+
+// record keeps the prices of the new snapshots, the upstream prices are the originals by the ID of the Properties
+// the snapshots are marked with the last change of the price of every Property, so they must not be cached yet
+// the Properties not seen for the retention are forgotten
+func (s *priceStore) record(config *config.Config, snapshots map[string]*snapshot, originals map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logger := config.Logger.WithField("job", "prices")
+	if err := s.open(config); err != nil {
+		logger.WithError(err).Error("Price history not loaded")
+		return
+	}
+	changed := 0
+	for name, current := range snapshots {
+		entries, ok := s.sources[name]
+		if !ok {
+			entries = map[string]*priceEntry{}
+			s.sources[name] = entries
+		}
+		observedAt := current.CreatedAt.UTC().Format(time.RFC3339)
+		for i := range current.Properties {
+			property := &current.Properties[i]
+			price, adjustedPrice := newMoney(originals[property.Id]), newMoney(property.PricingInfos.Price)
+			if price == nil || adjustedPrice == nil {
+				continue
+			}
+			entry, ok := entries[property.Id]
+			if !ok {
+				entry = &priceEntry{}
+				entries[property.Id] = entry
+			}
+			last := len(entry.Prices) - 1
+			// A snapshot built by a request without cache can finish after a newer ingestion
+			newer := last < 0 || current.Version > entry.Prices[last].SnapshotVersion
+			if newer && (last < 0 || entry.Prices[last].Price.Amount != price.Amount ||
+				entry.Prices[last].AdjustedPrice.Amount != adjustedPrice.Amount) {
+				entry.Prices = append(entry.Prices, model.PricePoint{
+					ObservedAt:      observedAt,
+					SnapshotVersion: current.Version,
+					Price:           price,
+					AdjustedPrice:   adjustedPrice,
+				})
+				changed++
+			}
+			if newer {
+				entry.LastSeen = observedAt
+			}
+			if changedAt, dropped := entry.lastChange(); !changedAt.IsZero() {
+				current.priceChangedAt[i] = changedAt
+				if dropped {
+					current.priceDropped.set(i)
+				}
+			}
+		}
+		cutoff := current.CreatedAt.Add(-config.Prices.Retention)
+		for id, entry := range entries {
+			if lastSeen, err := time.Parse(time.RFC3339, entry.LastSeen); err == nil && lastSeen.Before(cutoff) {
+				delete(entries, id)
+				changed++
+			}
+		}
+	}
+	if changed == 0 {
+		return
+	}
+	logger.WithField("changes", changed).Info("Price history changed")
+	if err := s.save(); err != nil {
+		logger.WithError(err).Error("Price history not written")
+	}
+}
+
+// history returns a copy of the prices of the Property in the source, or false when it was never seen
+func (s *priceStore) history(config *config.Config, name, id string) ([]model.PricePoint, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.open(config); err != nil {
+		config.Logger.WithField("job", "prices").WithError(err).Error("Price history not loaded")
+		return nil, false
+	}
+	entry, ok := s.sources[name][id]
+	if !ok {
+		return nil, false
+	}
+	return append([]model.PricePoint{}, entry.Prices...), true
+}
+
+// GetPriceHistory answers every price observed for the Property in the source, the oldest first
+func GetPriceHistory(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	source, id := r.Header.Get("source"), mux.Vars(r)["id"]
+	logger := RequestLogger(config, r).WithFields(logrus.Fields{"source": source, "id": id})
+	if !config.Enabled(source) {
+		logger.Error("No property found for the source")
+		respondError(w, http.StatusNotFound, "Source not accepted.")
+		return
+	}
+	// The first ingestion records the prices of the source
+	if getSnapshotOr404(config, source, w, r) == nil {
+		return
+	}
+	points, ok := prices.history(config, source, id)
+	if !ok {
+		logger.Error("No price history for the Property")
+		respondError(w, http.StatusNotFound, "Property not found.")
+		return
+	}
+	respondJSON(w, http.StatusOK, &model.PriceHistory{Id: id, Source: source, Prices: points})
+}
@@ -1,49 +1,126 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"math"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/tracing"
 	"gitlab.com/zap-api/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GetAllProperties will recover all Properties for the requested source
 func GetAllProperties(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	response := listPropertiesOrError(config, w, r)
+	if response == nil {
+		return
+	}
+	_, span := tracing.Start(r.Context(), "serialize response")
+	respondJSON(w, http.StatusOK, response)
+	span.End()
+}
+
+// GetAllPropertiesV2 will recover all Properties for the requested source with the typed money fields
+func GetAllPropertiesV2(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	response := listPropertiesOrError(config, w, r)
+	if response == nil {
+		return
+	}
+	properties := make([]model.PropertyV2, 0, len(response.Properties))
+	for i := range response.Properties {
+		properties = append(properties, toPropertyV2(&response.Properties[i]))
+	}
+	_, span := tracing.Start(r.Context(), "serialize response")
+	respondJSON(w, http.StatusOK, &model.ListPropertyResponseV2{
+		Properties:           properties,
+		PageNumber:           response.PageNumber,
+		PageSize:             response.PageSize,
+		PropertiesTotalCount: response.PropertiesTotalCount,
+		Facets:               response.Facets,
+	})
+	span.End()
+}
+
+// listPropertiesOrError filters and paginates the Properties of the source, or responds the error otherwise
+func listPropertiesOrError(config *config.Config, w http.ResponseWriter, r *http.Request) *model.ListPropertyResponse {
 	source := r.Header.Get("source")
-	config.Logger.Info("Recovering Properties for", source)
-	datasources := *config.Datasources
-	properties := &[]model.Property{}
-	if _, ok := datasources[source]; !ok {
-		config.Logger.Error("No property found for", source)
+	logger := RequestLogger(config, r).WithField("source", source)
+	logger.Info("Recovering Properties")
+	if !config.Enabled(source) {
+		logger.Error("No property found for the source")
 		respondError(w, http.StatusNotFound, "Source not accepted.")
-		return
+		return nil
 	}
-	propertiesCachedString, found := config.Cache.Get(source)
-	if !found {
-		config.Logger.Info("There is no cache for this request.")
-		config.Logger.Info("Requesting data from ZAP")
-		properties = getPropertiesOr404(config.Endpoints.ZapProperties, w, r)
-		if properties == nil {
-			config.Logger.Error("Request NOT FOUND")
-			return
-		}
-		properties = setCacheProperties(source, properties, config)
-	} else {
-		config.Logger.Info("Found a cache for this request")
-		properties = propertiesCachedString.(*[]model.Property)
+	facets, invalidFacet := parseFacets(r.URL.Query().Get("facets"))
+	if invalidFacet != "" {
+		logger.WithField("facet", invalidFacet).Error("Facet not accepted")
+		respondError(w, http.StatusBadRequest, "Facet not accepted: "+invalidFacet+".")
+		return nil
+	}
+	order := r.URL.Query().Get("sort")
+	if order != "" && order != sortRelevance && order != sortNewest {
+		logger.WithField("sort", order).Error("Sort not accepted")
+		respondError(w, http.StatusBadRequest, "Sort not accepted: "+order+".")
+		return nil
+	}
+	propertiesSnapshot := getSnapshotOr404(config, source, w, r)
+	if propertiesSnapshot == nil || notModified(w, r, source, propertiesSnapshot) {
+		return nil
 	}
-	respondJSON(w, http.StatusOK, paginate(config, r, properties))
+	selected := parseFilters(r.URL.Query()).apply(propertiesSnapshot)
+	properties := sortProperties(propertiesSnapshot.selection(selected), order, propertiesSnapshot.upstreamUpdatedAt)
+	response := paginate(logger, r, &properties, config.RateLimit.MaxPageSize)
+	if facets != nil {
+		response.Facets = countFacets(propertiesSnapshot, selected, facets)
+	}
+	return response
+}
+
+// getSnapshotOr404 gets the cached snapshot of the source, requesting the Properties when there is no cache
+func getSnapshotOr404(config *config.Config, source string, w http.ResponseWriter, r *http.Request) *snapshot {
+	logger := RequestLogger(config, r).WithField("source", source)
+	_, span := tracing.Start(r.Context(), "cache lookup")
+	span.SetAttributes(attribute.String("source", source))
+	propertiesCached, found := config.Cache.Get(source)
+	span.SetAttributes(attribute.Bool("cache.hit", found))
+	span.End()
+	if found {
+		cacheRequests.WithLabelValues(source, "hit").Inc()
+		logger.Debug("Found a cache for this request")
+		return propertiesCached.(*snapshot)
+	}
+	cacheRequests.WithLabelValues(source, "miss").Inc()
+	logger.Info("There is no cache for this request.")
+	logger.WithField("url", config.Endpoints.ZapProperties).Info("Requesting data from ZAP")
+	fetchedAt := time.Now()
+	properties := getPropertiesOr404(config.Endpoints.ZapProperties, w, r)
+	if properties == nil {
+		logger.Error("Request NOT FOUND")
+		ingestionErrors.record(config, "Request NOT FOUND")
+		return nil
+	}
+	lastFeed.set(properties, fetchedAt)
+	propertiesSnapshot := setCacheProperties(r.Context(), source, properties, fetchedAt, config, logger)
+	if propertiesSnapshot == nil {
+		logger.Error("Properties of a newer feed not found")
+		respondError(w, http.StatusNotFound, "Properties not found.")
+	}
+	return propertiesSnapshot
 }
 
 // getPropertiesOr404 gets all properties, or respond the 404 error otherwise
 func getPropertiesOr404(url string, w http.ResponseWriter, r *http.Request) *[]model.Property {
 	properties := []model.Property{}
-	if err := requestProperties(&properties, url); err != nil {
+	if err := requestProperties(r.Context(), &properties, url); err != nil {
 		respondError(w, http.StatusNotFound, err.Error())
 		return nil
 	}
@@ -51,37 +128,63 @@ func getPropertiesOr404(url string, w http.ResponseWriter, r *http.Request) *[]m
 }
 
 // the Timeout is 100s because it can take too long to make the first request without cache
-func requestProperties(target interface{}, url string) error {
+func requestProperties(ctx context.Context, target interface{}, url string) error {
 	var myClient = &http.Client{Timeout: 100 * time.Second}
-	r, err := myClient.Get(url)
+	start := time.Now()
+	fetchCtx, fetchSpan := tracing.Start(ctx, "upstream fetch", trace.WithSpanKind(trace.SpanKindClient))
+	fetchSpan.SetAttributes(attribute.String("http.url", url))
+	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
+		tracing.SetError(fetchSpan, err)
+		fetchSpan.End()
 		return err
 	}
-	defer r.Body.Close()
-	return json.NewDecoder(r.Body).Decode(target)
-}
-
-// Paginate just picksup a slice from the Response, showing just the page Requested
-func paginate(config *config.Config, r *http.Request, properties *[]model.Property) *model.ListPropertyResponse {
-	config.Logger.Info("Paginating the Response")
-	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	// The upstream continues the trace of the request with the W3C traceparent header
+	tracing.Inject(fetchCtx, request.Header)
+	r, err := myClient.Do(request.WithContext(ctx))
 	if err != nil {
-		offset = 0
+		upstreamFetches.WithLabelValues("error").Inc()
+		tracing.SetError(fetchSpan, err)
+		fetchSpan.End()
+		return err
 	}
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	defer r.Body.Close()
+	fetchSpan.SetAttributes(attribute.Int("http.status_code", r.StatusCode))
+	fetchSpan.End()
+	_, decodeSpan := tracing.Start(ctx, "decode JSON")
+	body := &countingReader{reader: r.Body}
+	err = json.NewDecoder(body).Decode(target)
+	upstreamDuration.Observe(time.Since(start).Seconds())
+	upstreamBytes.Add(float64(body.count))
+	decodeSpan.SetAttributes(attribute.Int64("bytes", body.count))
 	if err != nil {
-		limit = 10
+		upstreamFetches.WithLabelValues("error").Inc()
+		tracing.SetError(decodeSpan, err)
+		decodeSpan.End()
+		return err
 	}
-	if offset*limit > len(*properties)-1 {
-		config.Logger.Error("Offset bigger than the Response")
+	decodeSpan.End()
+	upstreamFetches.WithLabelValues("success").Inc()
+	return nil
+}
+
+// Paginate just picksup a slice from the Response, showing just the page Requested
+// the limit is capped by the maxLimit, the pageSize of the response tells the limit used
+func paginate(logger *logrus.Entry, r *http.Request, properties *[]model.Property, maxLimit int) *model.ListPropertyResponse {
+	logger.Debug("Paginating the Response")
+	offset, limit := pageParams(logger, r, maxLimit)
+	// the offset is compared by a division, its product by the limit overflows when huge
+	if len(*properties) == 0 || offset > (len(*properties)-1)/limit {
+		logger.WithFields(logrus.Fields{"offset": offset, "limit": limit}).Error("Offset bigger than the Response")
 		return &model.ListPropertyResponse{}
 	}
-	if offset+limit > len(*properties) {
-		limit = len(*properties)
+	end := (offset * limit) + limit
+	if end > len(*properties) {
+		end = len(*properties)
 	}
-	test := (*properties)[offset*limit : (offset*limit)+limit]
+	page := (*properties)[offset*limit : end]
 	listPropertyResponse := model.ListPropertyResponse{
-		Properties:           test,
+		Properties:           page,
 		PageNumber:           offset,
 		PageSize:             limit,
 		PropertiesTotalCount: len(*properties),
@@ -89,83 +192,161 @@ func paginate(config *config.Config, r *http.Request, properties *[]model.Proper
 	return &listPropertyResponse
 }
 
+// pageParams reads the page number of the offset and the page size of the limit, capped by the maximum page size
+func pageParams(logger *logrus.Entry, r *http.Request, maxLimit int) (int, int) {
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > maxLimit {
+		logger.WithFields(logrus.Fields{"limit": limit, "maxLimit": maxLimit}).Warn("Limit above the maximum page size")
+		limit = maxLimit
+	}
+	return offset, limit
+}
+
+// evaluation serializes the evaluations of the ingestion, of the reloads and of the requests without cache
+// the fetchedAt is of the last feed evaluated, the feeds requested before it are dropped
+var evaluation = struct {
+	sync.Mutex
+	fetchedAt time.Time
+}{}
+
 // setCacheProperties will create a cache for the possible requests
 // since the JSON returned is too big, the next requests will all be recovered by the cache
 // the first request will analyze each property, distribute in different caches and return just the selected source
-func setCacheProperties(source string, properties *[]model.Property, config *config.Config) *[]model.Property {
-	config.Logger.Info("Setting up the Response Cache for future Requests.")
-	zapProperties := []model.Property{}
-	vivaRealProperties := []model.Property{}
+// a feed requested before the last one evaluated does not replace its snapshots, the cached one is returned
+func setCacheProperties(ctx context.Context, source string, properties *[]model.Property, fetchedAt time.Time, config *config.Config, logger *logrus.Entry) *snapshot {
+	evaluation.Lock()
+	defer evaluation.Unlock()
+	if fetchedAt.Before(evaluation.fetchedAt) {
+		logger.WithField("fetchedAt", fetchedAt.UTC().Format(time.RFC3339Nano)).Warn("Feed older than the last evaluated, it is dropped")
+		cached, found := config.Cache.Get(source)
+		if !found {
+			return nil
+		}
+		return cached.(*snapshot)
+	}
+	evaluation.fetchedAt = fetchedAt
+	logger.WithField("properties", len(*properties)).Info("Setting up the Response Cache for future Requests.")
+	_, span := tracing.Start(ctx, "evaluate rules")
+	span.SetAttributes(attribute.Int("properties", len(*properties)))
+	defer span.End()
+	sources := config.SortedDatasources()
+	accepted := map[string][]model.Property{}
+	// originals are the upstream prices, before the campaigns of every source, and upstreamUpdatedAt their updatedAt
+	originals := map[string]string{}
+	upstreamUpdatedAt := map[string]upstreamTime{}
+	for _, name := range sources {
+		accepted[name] = []model.Property{}
+	}
 	for _, property := range *properties {
-		businessType := property.PricingInfos.BusinessType
 		price, err := strconv.Atoi(property.PricingInfos.Price)
 		// Price is converted before everything, if it fails the property is rejected early
+		if err != nil {
+			rejectForEverySource(config, "price_not_integer")
+			continue
+		}
 		// Verifies if lat and lon are 0, if they are the property is rejected
-		if err == nil && !isLatLonValid(property.Address.GeoLocation.Location) {
-			if isZapPropertyValid(property.UsableAreas, price, businessType) {
-				if isInsideBoundingBox(property.Address.GeoLocation.Location.Lat, property.Address.GeoLocation.Location.Lon) &&
-					businessType == "SALE" {
-					property.PricingInfos.Price = strconv.FormatFloat((float64(price) * (0.9)), 'f', 6, 64)
-					property.UpdatedAt = time.Now().Format(time.RFC3339)
-				}
-				zapProperties = append(zapProperties, property)
-			}
-			if isVivaRealPropertyValid(property.PricingInfos.MonthlyCondoFee, price, businessType) {
-				if isInsideBoundingBox(property.Address.GeoLocation.Location.Lat, property.Address.GeoLocation.Location.Lon) &&
-					businessType == "RENTAL" {
-					property.PricingInfos.Price = strconv.FormatFloat((float64(price) * (1.5)), 'f', 6, 64)
-					property.UpdatedAt = time.Now().Format(time.RFC3339)
-				}
-				vivaRealProperties = append(vivaRealProperties, property)
+		if isLatLonValid(property.Address.GeoLocation.Location) {
+			rejectForEverySource(config, "no_location")
+			continue
+		}
+		originals[property.Id] = property.PricingInfos.Price
+		upstreamUpdatedAt[property.Id] = newUpstreamTime(property.UpdatedAt)
+		for _, name := range sources {
+			if rule := rejectedRule((*config.Rules)[name], &property, price); rule != "" {
+				ingestionRejected.WithLabelValues(name, rule).Inc()
+				continue
 			}
+			// Every source has its own copy, so the campaigns of a source do not change the prices of the others
+			sourceProperty := property
+			applyCampaigns((*config.Campaigns)[name], *config.Zones, &sourceProperty, price)
+			accepted[name] = append(accepted[name], sourceProperty)
+		}
+	}
+	createdAt := time.Now()
+	snapshots := map[string]*snapshot{}
+	counts := logrus.Fields{}
+	quarantined := map[string][]model.Property{}
+	for _, name := range sources {
+		accepted[name], quarantined[name] = detectAnomalies(config.Anomalies, accepted[name], originals)
+		ingestionRejected.WithLabelValues(name, "quarantine").Add(float64(len(quarantined[name])))
+		ingestionAccepted.WithLabelValues(name).Add(float64(len(accepted[name])))
+		markDuplicates(config.Dedup, accepted[name])
+		scoreProperties(config.QualityOf(name), accepted[name], upstreamUpdatedAt, createdAt)
+		snapshots[name] = newSnapshot(accepted[name], createdAt, upstreamUpdatedAt)
+		counts[name] = len(accepted[name])
+	}
+	quarantine.Lock()
+	quarantine.sources = quarantined
+	quarantine.Unlock()
+	prices.record(config, snapshots, originals)
+	for _, name := range sources {
+		config.Cache.Set(name, snapshots[name], cache.DefaultExpiration)
+	}
+	searches.match(config, snapshots, changes.record(config, snapshots))
+	// The snapshots of the sources disabled by a reload are not kept
+	for name := range config.Cache.Items() {
+		if !config.Enabled(name) {
+			config.Cache.Delete(name)
 		}
 	}
-	config.Cache.Set("zap", &zapProperties, cache.DefaultExpiration)
-	config.Cache.Set("vivareal", &vivaRealProperties, cache.DefaultExpiration)
-	config.Logger.Info("Responding with Properties for.", source)
-	if source == "zap" {
-		return &zapProperties
+	logger.WithFields(counts).Info("Responding with Properties for the source")
+	return snapshots[source]
+}
+
+// rejectedRule returns the rule of the source rejecting the property, or empty when it is accepted
+func rejectedRule(rules *config.Rules, property *model.Property, price int) string {
+	businessType := property.PricingInfos.BusinessType
+	salePrice := businessType == "SALE" && price >= rules.SaleMinPrice
+	rentalPrice := businessType == "RENTAL" && price >= rules.RentalMinPrice
+	if !salePrice && !rentalPrice {
+		return "minimum_price"
+	}
+	if businessType == "RENTAL" && rules.RentalMinSquareMeterPrice > 0 &&
+		!isSquareMeterAcceptable(price, property.UsableAreas, rules.RentalMinSquareMeterPrice) {
+		return "square_meter"
+	}
+	if businessType == "RENTAL" && rules.RentalMaxCondoFeeRatio > 0 &&
+		!isRentalPriceAndCondoFeeAcceptable(property.PricingInfos.MonthlyCondoFee, price, rules.RentalMaxCondoFeeRatio) {
+		return "condo_fee"
 	}
-	return &vivaRealProperties
+	return ""
 }
 
-func isZapPropertyValid(usableAreas int, price int, businessType string) bool {
-	acceptableSquareMeter := true
-	if businessType == "RENTAL" {
-		acceptableSquareMeter = isSquareMeterAcceptable(price, usableAreas)
+// applyCampaigns multiplies the price of the property for the campaigns of its business type and zone
+func applyCampaigns(campaigns []*config.Campaign, zones map[string]*config.Zone, property *model.Property, price int) {
+	location := property.Address.GeoLocation.Location
+	for _, campaign := range campaigns {
+		if property.PricingInfos.BusinessType == campaign.BusinessType &&
+			isInsideBoundingBox(zones[campaign.Zone], location.Lat, location.Lon) {
+			property.PricingInfos.Price = strconv.FormatFloat((float64(price) * campaign.PriceMultiplier), 'f', 6, 64)
+			property.UpdatedAt = time.Now().Format(time.RFC3339)
+		}
 	}
-	salePrice := businessType == "SALE" && price >= 600000
-	rentalPrice := businessType == "RENTAL" && price >= 3500
-	return (salePrice || rentalPrice) && acceptableSquareMeter
 }
 
-// Verifies if the square meter value is acceptable for Zap
-func isSquareMeterAcceptable(price, usableAreas int) bool {
+// Verifies if the square meter value is acceptable
+func isSquareMeterAcceptable(price, usableAreas, minSquareMeterPrice int) bool {
 	if usableAreas > 0 {
-		return (price / usableAreas) > 3500
+		return (price / usableAreas) > minSquareMeterPrice
 	}
 	// It must consider just the usableAreas above 0
 	return false
 }
 
-// Verifies the monthlyCondoFee againts 30% of the Rental price
-// both must be parsed to float because the comparison is made with 30% of the price
-// And verifies the old rules of minimum price for SALE and RENTAL
-func isVivaRealPropertyValid(monthlyCondoFee string, price int, businessType string) bool {
-	rentalPriceCondoComparison := true
-	if businessType == "RENTAL" {
-		rentalPriceCondoComparison = isRentalPriceAndCondoFeeAcceptable(monthlyCondoFee, price)
-	}
-	salePrice := businessType == "SALE" && price >= 700000
-	rentalPrice := businessType == "RENTAL" && price >= 4000
-	return (salePrice || rentalPrice) && rentalPriceCondoComparison
-}
-
-func isRentalPriceAndCondoFeeAcceptable(propertyMonthlyCondoFee string, rentalPrice int) bool {
+// Verifies the monthlyCondoFee againts the ratio of the Rental price, like 30%
+// both must be parsed to float because the comparison is made with a ratio of the price
+func isRentalPriceAndCondoFeeAcceptable(propertyMonthlyCondoFee string, rentalPrice int, maxRatio float64) bool {
 	monthlyCondoFee, err := strconv.ParseFloat(propertyMonthlyCondoFee, 64)
 	// If the value is not valid and returns an error, then it is valid
 	if err == nil {
-		if monthlyCondoFee < float64(rentalPrice)*0.3 {
+		if monthlyCondoFee < float64(rentalPrice)*maxRatio {
 			return true
 		}
 		// If price is not a real value, then it will not able to compare with the monthlyCondoFee
@@ -182,19 +363,19 @@ func isLatLonValid(location model.Location) bool {
 	return false
 }
 
-func isInsideBoundingBox(x, y float64) bool {
+func isInsideBoundingBox(zone *config.Zone, x, y float64) bool {
 	// Describing the points by the coordinates
-	x1 := model.VivaRealBoundBox.Maxlat
-	y1 := model.VivaRealBoundBox.Minlon
+	x1 := zone.MaxLat
+	y1 := zone.MinLon
 
-	x2 := model.VivaRealBoundBox.Maxlat
-	y2 := model.VivaRealBoundBox.Maxlon
+	x2 := zone.MaxLat
+	y2 := zone.MaxLon
 
-	x3 := model.VivaRealBoundBox.Minlat
-	y3 := model.VivaRealBoundBox.Maxlon
+	x3 := zone.MinLat
+	y3 := zone.MaxLon
 
-	x4 := model.VivaRealBoundBox.Minlat
-	y4 := model.VivaRealBoundBox.Minlon
+	x4 := zone.MinLat
+	y4 := zone.MinLon
 
 	// Calculating all the areas
 	// A = area total
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// Orders of the Properties, the snapshots are already in the order of the relevance
+const (
+	sortRelevance = "relevance"
+	sortNewest    = "newest"
+)
+
+// precisionScores of the GeoLocation, the other precisions have no score
+var precisionScores = map[string]float64{
+	"ROOFTOP":            1,
+	"RANGE_INTERPOLATED": 0.75,
+	"GEOMETRIC_CENTER":   0.5,
+	"APPROXIMATE":        0.25,
+}
+
+// scoreProperties sets the QualityScore of the Properties of a source, then sorts them by the relevance
+// the best scores first, and the ties in the order of their IDs so the pages are the same on every ingestion
+// the recency is of the upstream updatedAt, the campaigns set it to the time of the ingestion
+func scoreProperties(quality *config.Quality, properties []model.Property, upstreamUpdatedAt map[string]upstreamTime, now time.Time) {
+	for i := range properties {
+		properties[i].QualityScore = qualityScore(quality, &properties[i], upstreamUpdatedAt[properties[i].Id], now)
+	}
+	sort.SliceStable(properties, func(i, j int) bool {
+		if properties[i].QualityScore != properties[j].QualityScore {
+			return properties[i].QualityScore > properties[j].QualityScore
+		}
+		return properties[i].Id < properties[j].Id
+	})
+}
+
+// qualityScore is the weighted average of the parts of the score, from 0 to 100 with two decimals
+// the updatedAt not parsed has no recency
+func qualityScore(quality *config.Quality, property *model.Property, upstreamUpdatedAt upstreamTime, now time.Time) float64 {
+	images := math.Min(float64(len(property.Images)), float64(quality.MaxImages)) / float64(quality.MaxImages)
+	location := precisionScores[property.Address.GeoLocation.Precision]
+	recency := 0.0
+	if upstreamUpdatedAt.valid {
+		recency = math.Max(0, math.Min(1, 1-float64(now.Sub(upstreamUpdatedAt.time))/float64(quality.StaleAfter)))
+	}
+	weights := quality.Images + quality.Location + quality.Pricing + quality.Recency
+	score := quality.Images*images + quality.Location*location + quality.Pricing*pricingCompleteness(property) + quality.Recency*recency
+	return math.Round(score/weights*10000) / 100
+}
+
+// pricingCompleteness is the share of the pricing fields informed, the rentals also have the total price
+func pricingCompleteness(property *model.Property) float64 {
+	pricing := property.PricingInfos
+	fields := []string{pricing.YearlyIptu, pricing.MonthlyCondoFee}
+	if pricing.BusinessType == "RENTAL" {
+		fields = append(fields, pricing.RentalTotalPrice)
+	}
+	informed := 0
+	for _, field := range fields {
+		if _, ok := parsePrice(field); ok {
+			informed++
+		}
+	}
+	return float64(informed) / float64(len(fields))
+}
+
+// sortProperties orders the Properties selected from a snapshot, they are copied unless the order is the relevance
+// the newest are the ones of the most recent upstream updatedAt of the snapshot, the ones not parsed are the last
+// and the ties are in the order of their IDs
+func sortProperties(properties []model.Property, order string, upstreamUpdatedAt map[string]upstreamTime) []model.Property {
+	if order != sortNewest {
+		return properties
+	}
+	sorted := append([]model.Property(nil), properties...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		first, second := upstreamUpdatedAt[sorted[i].Id], upstreamUpdatedAt[sorted[j].Id]
+		if first.valid != second.valid {
+			return first.valid
+		}
+		if !first.time.Equal(second.time) {
+			return first.time.After(second.time)
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+	return sorted
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/ratelimit"
+	"gitlab.com/zap-api/config"
+)
+
+// limiter keeps the buckets and quotas of the clients, they are kept on the reloads of the configuration
+var limiter = ratelimit.New()
+
+// RateLimit takes a token of the client, and of the client on the route, and counts the request in its daily quota
+// the RateLimit headers tell the limit closest to be exhausted, and it responds 429 returning false when it is
+func RateLimit(config *config.Config, w http.ResponseWriter, r *http.Request) bool {
+	key, limit := "ip:"+clientIP(config, r), config.RateLimit.Default
+	if client := ClientOf(r); client != nil {
+		key = "client:" + client.Name
+		if clientLimit, ok := config.RateLimit.Clients[client.Name]; ok {
+			limit = clientLimit
+		}
+	}
+	route := routeName(config, r)
+	buckets := []ratelimit.Bucket{}
+	if limit.RequestsPerSecond > 0 {
+		buckets = append(buckets, ratelimit.Bucket{Key: key, Rate: limit.RequestsPerSecond, Burst: limit.Burst})
+	}
+	if routeLimit, ok := config.RateLimit.Routes[route]; ok && routeLimit.RequestsPerSecond > 0 {
+		buckets = append(buckets, ratelimit.Bucket{Key: key + " " + route, Rate: routeLimit.RequestsPerSecond, Burst: routeLimit.Burst})
+	}
+	decision := limiter.Allow(time.Now(), key, limit.DailyQuota, buckets...)
+	if len(buckets) > 0 || limit.DailyQuota > 0 {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(decision.Reset))
+	}
+	if !decision.Allowed {
+		RequestLogger(config, r).WithField("key", key).WithField("route", route).Warn("Rate limit exceeded")
+		rateLimited.WithLabelValues(route).Inc()
+		w.Header().Set("Retry-After", ceilSeconds(decision.RetryAfter))
+		respondError(w, http.StatusTooManyRequests, "Too many requests.")
+		return false
+	}
+	return true
+}
+
+// GetQuotas responds the requests of the day of every client with a daily quota, and their quotas
+func GetQuotas(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	day, usages := limiter.Usages(time.Now())
+	quotas := model.Quotas{Day: day, Clients: []model.ClientQuota{}}
+	for _, usage := range usages {
+		quotas.Clients = append(quotas.Clients, model.ClientQuota{Client: usage.Key, Requests: usage.Requests, Quota: usage.Quota})
+	}
+	respondJSON(w, http.StatusOK, &quotas)
+}
+
+// ResetRateLimits forgets the buckets and the daily counts of every client
+func ResetRateLimits() {
+	limiter.Reset()
+}
+
+// clientIP is the IP of the connection, or the last of the X-Forwarded-For when the proxy is trusted
+// the last one is added by the proxy, the ones before it are sent by the client and can be forged
+func clientIP(config *config.Config, r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); config.RateLimit.TrustForwardedFor && forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// routeName is the path of the route without the version, so the limits of a route are shared by its versions
+func routeName(config *config.Config, r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.URL.Path
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return r.URL.Path
+	}
+	for version := range *config.Versions {
+		if strings.HasPrefix(path, "/"+version+"/") {
+			return strings.TrimPrefix(path, "/"+version)
+		}
+	}
+	return path
+}
+
+func ceilSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
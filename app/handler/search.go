@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"gitlab.com/zap-api/app/model"
+)
+
+// accentFolding replaces the Portuguese accented letters, so "São Paulo" is found as "sao paulo"
+var accentFolding = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// fold lower cases the text and removes its accents
+func fold(text string) string {
+	return accentFolding.Replace(strings.ToLower(text))
+}
+
+// tokenize splits the folded text in words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(fold(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// place is a City or Neighborhood that can be suggested by the autocomplete
+type place struct {
+	kind   string
+	name   string
+	folded string
+	words  []string
+	count  int
+}
+
+// textIndex is the inverted index of the words in the Address City and Neighborhood
+type textIndex struct {
+	size  int
+	words map[string]bitset
+	// sorted keeps the words in order, so every word with a prefix is found by a binary search
+	sorted []string
+	places []*place
+}
+
+func newTextIndex(properties []model.Property) *textIndex {
+	index := &textIndex{size: len(properties), words: map[string]bitset{}}
+	places := map[string]*place{}
+	for i := range properties {
+		address := properties[i].Address
+		for kind, name := range map[string]string{"city": address.City, "neighborhood": address.Neighborhood} {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			words := tokenize(name)
+			for _, word := range words {
+				positions, ok := index.words[word]
+				if !ok {
+					positions = newBitset(index.size)
+					index.words[word] = positions
+				}
+				positions.set(i)
+			}
+			key := kind + ":" + fold(name)
+			if _, ok := places[key]; !ok {
+				places[key] = &place{kind: kind, name: name, folded: fold(name), words: words}
+				index.places = append(index.places, places[key])
+			}
+			places[key].count++
+		}
+	}
+	for word := range index.words {
+		index.sorted = append(index.sorted, word)
+	}
+	sort.Strings(index.sorted)
+	return index
+}
+
+// search returns the positions of the Properties that have every word of the query as a prefix of some word
+func (index *textIndex) search(query string) bitset {
+	selected := fullBitset(index.size)
+	for _, word := range tokenize(query) {
+		matches := newBitset(index.size)
+		for i := sort.SearchStrings(index.sorted, word); i < len(index.sorted) && strings.HasPrefix(index.sorted[i], word); i++ {
+			for j, positions := range index.words[index.sorted[i]] {
+				matches[j] |= positions
+			}
+		}
+		selected.and(matches)
+	}
+	return selected
+}
+
+// matchPlace ranks how the place matches the query, 0 means it does not match
+// the name starting with the query ranks above a query matching just the start of its words
+func matchPlace(p *place, query string, words []string) int {
+	for _, word := range words {
+		found := false
+		for _, placeWord := range p.words {
+			if strings.HasPrefix(placeWord, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	if strings.HasPrefix(p.folded, query) {
+		return 2
+	}
+	return 1
+}
+
+// suggest returns the places of every snapshot matching the query, ranked and with the count per source
+func suggest(snapshots map[string]*snapshot, query string, limit int) []model.Suggestion {
+	folded := strings.Join(tokenize(query), " ")
+	words := tokenize(query)
+	suggestions := map[string]*model.Suggestion{}
+	ranks := map[string]int{}
+	for source, s := range snapshots {
+		for _, p := range s.text.places {
+			rank := matchPlace(p, folded, words)
+			if rank == 0 {
+				continue
+			}
+			key := p.kind + ":" + p.folded
+			suggestion, ok := suggestions[key]
+			if !ok {
+				suggestion = &model.Suggestion{Text: p.name, Type: p.kind, Counts: map[string]int{}}
+				suggestions[key] = suggestion
+				ranks[key] = rank
+			}
+			suggestion.Counts[source] += p.count
+			suggestion.TotalCount += p.count
+		}
+	}
+	keys := make([]string, 0, len(suggestions))
+	for key := range suggestions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ranks[keys[i]] != ranks[keys[j]] {
+			return ranks[keys[i]] > ranks[keys[j]]
+		}
+		if suggestions[keys[i]].TotalCount != suggestions[keys[j]].TotalCount {
+			return suggestions[keys[i]].TotalCount > suggestions[keys[j]].TotalCount
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	ranked := make([]model.Suggestion, 0, len(keys))
+	for _, key := range keys {
+		ranked = append(ranked, *suggestions[key])
+	}
+	return ranked
+}
@@ -0,0 +1,626 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// Status of the deliveries of the webhooks
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// SignatureHeader has the time and the HMAC-SHA256 of the webhooks, like "t=1546300800,v1=<hex>"
+// the HMAC is of the time, a dot and the body, with the secret of the search
+const SignatureHeader = "X-Signature"
+
+// DeliveryIDHeader is the same on every attempt of a delivery, so the receivers can ignore the repeated ones
+const DeliveryIDHeader = "X-Delivery-ID"
+
+// savedSearch is kept with the client owning it and its secret
+type savedSearch struct {
+	model.SavedSearch
+	Client string `json:"client,omitempty"`
+}
+
+// view is the search answered to the clients, without the secret
+func (s *savedSearch) view() model.SavedSearch {
+	search := s.SavedSearch
+	search.Secret = ""
+	return search
+}
+
+// delivery is kept with the body of the webhook, so it is the same on every attempt
+type delivery struct {
+	model.Delivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+// searchesFile is the content of the file of the searches
+type searchesFile struct {
+	Searches   []*savedSearch `json:"searches"`
+	Deliveries []*delivery    `json:"deliveries"`
+}
+
+// searchStore keeps the searches and the deliveries in memory, every change is written to the file
+// the file is loaded again when the configuration points to another one
+type searchStore struct {
+	mutex      sync.Mutex
+	file       string
+	searches   map[string]*savedSearch
+	deliveries []*delivery
+	timers     map[string]*time.Timer
+}
+
+var searches = &searchStore{}
+
+// open loads the file of the configuration, a missing file has no searches
+// the pending deliveries are scheduled again, at their next attempt
+func (s *searchStore) open(config *config.Config) error {
+	if s.file == config.Searches.File && s.searches != nil {
+		return nil
+	}
+	content := searchesFile{}
+	data, err := ioutil.ReadFile(config.Searches.File)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return err
+		}
+	}
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+	s.file = config.Searches.File
+	s.searches = map[string]*savedSearch{}
+	s.deliveries = content.Deliveries
+	s.timers = map[string]*time.Timer{}
+	for _, search := range content.Searches {
+		s.searches[search.Id] = search
+	}
+	for _, pending := range s.deliveries {
+		if pending.Status == deliveryPending {
+			next, _ := time.Parse(time.RFC3339, pending.NextAttemptAt)
+			s.schedule(config, pending, time.Until(next))
+		}
+	}
+	return nil
+}
+
+// save writes the file at once, through a temporary file renamed over it
+func (s *searchStore) save() error {
+	content := searchesFile{Searches: s.sorted(""), Deliveries: s.deliveries}
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.file+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(s.file+".tmp", s.file)
+}
+
+// sorted returns the searches of the client in the order of creation, every search for the empty client
+func (s *searchStore) sorted(client string) []*savedSearch {
+	sorted := []*savedSearch{}
+	for _, search := range s.searches {
+		if client == "" || search.Client == client {
+			sorted = append(sorted, search)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt != sorted[j].CreatedAt {
+			return sorted[i].CreatedAt < sorted[j].CreatedAt
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+	return sorted
+}
+
+func (s *searchStore) find(id string) *delivery {
+	for _, found := range s.deliveries {
+		if found.Id == id {
+			return found
+		}
+	}
+	return nil
+}
+
+// schedule the next attempt of the delivery
+func (s *searchStore) schedule(config *config.Config, pending *delivery, wait time.Duration) {
+	id := pending.Id
+	s.timers[id] = time.AfterFunc(wait, func() {
+		s.attempt(config, id)
+	})
+}
+
+// trim removes the oldest deliveries finished above the history
+func (s *searchStore) trim(config *config.Config) {
+	finished := 0
+	for _, kept := range s.deliveries {
+		if kept.Status != deliveryPending {
+			finished++
+		}
+	}
+	kept := s.deliveries[:0]
+	for _, current := range s.deliveries {
+		if current.Status != deliveryPending && finished > config.Searches.History {
+			finished--
+			continue
+		}
+		kept = append(kept, current)
+	}
+	s.deliveries = kept
+}
+
+// match queues a delivery for every search with new Properties matching it, the Properties added by the ingestion
+func (s *searchStore) match(config *config.Config, snapshots map[string]*snapshot, recorded map[string][]model.Change) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logger := config.Logger.WithField("job", "searches")
+	if err := s.open(config); err != nil {
+		logger.WithError(err).Error("Saved searches not loaded")
+		return
+	}
+	queued := 0
+	for _, search := range s.sorted("") {
+		current := snapshots[search.Source]
+		added := map[string]bool{}
+		for _, change := range recorded[search.Source] {
+			if change.Type == changeAdded {
+				added[change.Id] = true
+			}
+		}
+		if current == nil || len(added) == 0 {
+			continue
+		}
+		selected := parseFilters(filterQuery(search.Filters)).apply(current)
+		match := model.SearchMatch{SearchId: search.Id, Source: search.Source, SnapshotVersion: current.Version, Listings: []model.PropertyV2{}}
+		ids := []string{}
+		for i := range current.Properties {
+			property := &current.Properties[i]
+			if selected.has(i) && added[property.Id] && withinRadius(search.Radius, property.Address.GeoLocation.Location) {
+				match.Listings = append(match.Listings, toPropertyV2(property))
+				ids = append(ids, property.Id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		match.DeliveryId = newRequestID()
+		payload, _ := json.Marshal(&match)
+		queuedDelivery := &delivery{
+			Delivery: model.Delivery{
+				Id:              match.DeliveryId,
+				SearchId:        search.Id,
+				Status:          deliveryPending,
+				SnapshotVersion: current.Version,
+				Listings:        ids,
+				CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+			},
+			Payload: payload,
+		}
+		s.deliveries = append(s.deliveries, queuedDelivery)
+		s.schedule(config, queuedDelivery, 0)
+		queued++
+	}
+	if queued == 0 {
+		return
+	}
+	logger.WithField("deliveries", queued).Info("New Properties matching the saved searches")
+	s.trim(config)
+	if err := s.save(); err != nil {
+		logger.WithError(err).Error("Saved searches not written")
+	}
+}
+
+// attempt posts the webhook without holding the store, then records the result and schedules the retry
+// the wait is the backoff doubled on every attempt, the delivery is dead after the maximum of attempts
+func (s *searchStore) attempt(config *config.Config, id string) {
+	s.mutex.Lock()
+	pending := s.find(id)
+	if pending == nil || pending.Status != deliveryPending || s.searches[pending.SearchId] == nil {
+		s.mutex.Unlock()
+		return
+	}
+	file := s.file
+	search := s.searches[pending.SearchId].SavedSearch
+	payload := pending.Payload
+	s.mutex.Unlock()
+
+	status, err := postWebhook(config, search.WebhookURL, search.Secret, id, payload)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if pending = s.find(id); s.file != file || pending == nil || pending.Status != deliveryPending {
+		return
+	}
+	logger := config.Logger.WithField("job", "searches").WithField("deliveryId", id).WithField("searchId", search.Id)
+	delete(s.timers, id)
+	now := time.Now().UTC()
+	pending.Attempts++
+	pending.LastAttemptAt = now.Format(time.RFC3339)
+	pending.LastStatusCode = status
+	pending.NextAttemptAt = ""
+	switch {
+	case err == nil:
+		pending.Status = deliveryDelivered
+		pending.DeliveredAt = now.Format(time.RFC3339)
+		pending.LastError = ""
+		webhookDeliveries.WithLabelValues(deliveryDelivered).Inc()
+		logger.Info("Webhook delivered")
+	case pending.Attempts >= config.Searches.MaxAttempts:
+		pending.Status = deliveryDead
+		pending.LastError = err.Error()
+		webhookDeliveries.WithLabelValues(deliveryDead).Inc()
+		logger.WithError(err).Error("Webhook dead after the last attempt")
+	default:
+		pending.LastError = err.Error()
+		wait := config.Searches.Backoff * time.Duration(1<<uint(pending.Attempts-1))
+		pending.NextAttemptAt = now.Add(wait).Format(time.RFC3339)
+		s.schedule(config, pending, wait)
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		logger.WithError(err).WithField("wait", wait.String()).Warn("Webhook failed, retrying")
+	}
+	s.trim(config)
+	if err := s.save(); err != nil {
+		logger.WithError(err).Error("Saved searches not written")
+	}
+}
+
+// postWebhook signs the body with the secret and posts it, the answers other than 2xx are errors
+func postWebhook(config *config.Config, webhookURL, secret, id string, payload []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := hmac.New(sha256.New, []byte(secret))
+	signature.Write([]byte(timestamp + "."))
+	signature.Write(payload)
+	request, err := http.NewRequest("POST", webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "zap-api")
+	request.Header.Set(DeliveryIDHeader, id)
+	request.Header.Set(SignatureHeader, "t="+timestamp+",v1="+hex.EncodeToString(signature.Sum(nil)))
+	// The redirects are answered as they are, they would take the webhook to the addresses not checked
+	client := &http.Client{Timeout: config.Searches.Timeout, Transport: publicTransport, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	if config.Searches.AllowPrivateWebhooks {
+		client.Transport = http.DefaultTransport
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook answered %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// publicTransport dials just the public addresses, the host of the webhook is checked again when it is connected
+// since it may resolve to another address than the one checked by validateSearch
+var publicTransport = &http.Transport{
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublic}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// dialPublic refuses the connections to the addresses not public, the address is already resolved
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("webhook address %s not public", host)
+	}
+	return nil
+}
+
+// publicAddress tells if the IP is not loopback, link-local, private, multicast or unspecified
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsPrivate() && !ip.IsUnspecified()
+}
+
+// publicHost tells if every address of the host is public, the hosts not resolved are not
+func publicHost(host string) bool {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// earthRadius in meters, for the distances between the locations
+const earthRadius = 6371000
+
+// withinRadius tells if the location is inside the radius by the haversine distance, every location is without radius
+func withinRadius(radius *model.GeoRadius, location model.Location) bool {
+	if radius == nil {
+		return true
+	}
+	return distance(model.Location{Lat: radius.Lat, Lon: radius.Lon}, location) <= radius.Meters
+}
+
+// distance in meters between the locations, by the haversine formula
+func distance(from, to model.Location) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	lat1, lat2 := toRadians(from.Lat), toRadians(to.Lat)
+	deltaLat, deltaLon := lat2-lat1, toRadians(to.Lon-from.Lon)
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// validateSearch returns the message of the first field of the search not accepted, or empty
+func validateSearch(config *config.Config, search *model.SavedSearch) string {
+	if !config.Enabled(search.Source) {
+		return "Source not accepted."
+	}
+	if invalidFilter := validateFilters(search.Filters); invalidFilter != "" {
+		return "Filter not accepted: " + invalidFilter + "."
+	}
+	if radius := search.Radius; radius != nil &&
+		(radius.Meters <= 0 || math.Abs(radius.Lat) > 90 || math.Abs(radius.Lon) > 180) {
+		return "Radius not accepted."
+	}
+	parsed, err := url.Parse(search.WebhookURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "Webhook URL not accepted."
+	}
+	if !config.Searches.AllowPrivateWebhooks && !publicHost(parsed.Hostname()) {
+		return "Webhook URL not accepted: the host must be public."
+	}
+	return ""
+}
+
+// decodeSearch reads and validates the search of the body, responding the error otherwise
+func decodeSearch(config *config.Config, w http.ResponseWriter, r *http.Request) *model.SavedSearch {
+	logger := RequestLogger(config, r)
+	search := model.SavedSearch{}
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		logger.WithError(err).Error("Invalid body for the saved search")
+		respondError(w, http.StatusBadRequest, "Invalid body.")
+		return nil
+	}
+	if message := validateSearch(config, &search); message != "" {
+		logger.WithField("source", search.Source).Error("Saved search not accepted: " + message)
+		respondError(w, http.StatusBadRequest, message)
+		return nil
+	}
+	if !sourceAllowed(r, search.Source) {
+		logger.WithField("source", search.Source).Error("Source not allowed for the client")
+		respondError(w, http.StatusForbidden, "Source not allowed.")
+		return nil
+	}
+	return &search
+}
+
+// openSearches locks the store loaded from the file of the configuration, responding the error otherwise
+// the caller unlocks it when it returns true
+func openSearches(config *config.Config, w http.ResponseWriter, r *http.Request) bool {
+	searches.mutex.Lock()
+	if err := searches.open(config); err != nil {
+		searches.mutex.Unlock()
+		RequestLogger(config, r).WithError(err).Error("Saved searches not loaded")
+		respondError(w, http.StatusInternalServerError, "Saved searches not available.")
+		return false
+	}
+	return true
+}
+
+// saveSearches writes the store, responding the error when it fails
+func saveSearches(config *config.Config, w http.ResponseWriter, r *http.Request) bool {
+	if err := searches.save(); err != nil {
+		RequestLogger(config, r).WithError(err).Error("Saved searches not written")
+		respondError(w, http.StatusInternalServerError, "Saved searches not available.")
+		return false
+	}
+	return true
+}
+
+// searchOf returns the search of the path owned by the client, or responds 404
+func searchOf(config *config.Config, w http.ResponseWriter, r *http.Request) *savedSearch {
+	search, ok := searches.searches[mux.Vars(r)["id"]]
+	if !ok || search.Client != clientName(r) {
+		RequestLogger(config, r).WithField("searchId", mux.Vars(r)["id"]).Error("Saved search not found")
+		respondError(w, http.StatusNotFound, "Saved search not found.")
+		return nil
+	}
+	return search
+}
+
+// CreateSearch saves the search of the client, its secret is just answered now
+func CreateSearch(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	search := decodeSearch(config, w, r)
+	if search == nil || !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339)
+	search.Id = newRequestID()
+	search.Secret = newRequestID() + newRequestID()
+	search.CreatedAt, search.UpdatedAt = now, now
+	searches.searches[search.Id] = &savedSearch{SavedSearch: *search, Client: clientName(r)}
+	if !saveSearches(config, w, r) {
+		delete(searches.searches, search.Id)
+		return
+	}
+	RequestLogger(config, r).WithField("searchId", search.Id).Info("Search saved")
+	w.Header().Set("Location", r.URL.Path+"/"+search.Id)
+	respondJSON(w, http.StatusCreated, search)
+}
+
+// ListSearches answers the searches of the client
+func ListSearches(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	response := model.SavedSearches{Searches: []model.SavedSearch{}}
+	for _, search := range searches.sorted(clientName(r)) {
+		response.Searches = append(response.Searches, search.view())
+	}
+	respondJSON(w, http.StatusOK, &response)
+}
+
+// GetSearch answers the search of the client
+func GetSearch(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	if search := searchOf(config, w, r); search != nil {
+		view := search.view()
+		respondJSON(w, http.StatusOK, &view)
+	}
+}
+
+// UpdateSearch replaces the search of the client, its ID and its secret are kept
+func UpdateSearch(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	update := decodeSearch(config, w, r)
+	if update == nil || !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	search := searchOf(config, w, r)
+	if search == nil {
+		return
+	}
+	previous := search.SavedSearch
+	update.Id, update.Secret, update.CreatedAt = previous.Id, previous.Secret, previous.CreatedAt
+	update.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	search.SavedSearch = *update
+	if !saveSearches(config, w, r) {
+		search.SavedSearch = previous
+		return
+	}
+	RequestLogger(config, r).WithField("searchId", search.Id).Info("Search updated")
+	view := search.view()
+	respondJSON(w, http.StatusOK, &view)
+}
+
+// DeleteSearch removes the search of the client with its deliveries pending
+func DeleteSearch(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	search := searchOf(config, w, r)
+	if search == nil {
+		return
+	}
+	delete(searches.searches, search.Id)
+	kept := searches.deliveries[:0]
+	for _, current := range searches.deliveries {
+		if current.SearchId == search.Id && current.Status == deliveryPending {
+			if timer, ok := searches.timers[current.Id]; ok {
+				timer.Stop()
+				delete(searches.timers, current.Id)
+			}
+			continue
+		}
+		kept = append(kept, current)
+	}
+	searches.deliveries = kept
+	if !saveSearches(config, w, r) {
+		return
+	}
+	RequestLogger(config, r).WithField("searchId", search.Id).Info("Search deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeliveries answers the history of the deliveries of the search, the most recent first
+func GetDeliveries(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	search := searchOf(config, w, r)
+	if search == nil {
+		return
+	}
+	respondJSON(w, http.StatusOK, deliveriesOf(func(current *delivery) bool { return current.SearchId == search.Id }))
+}
+
+// GetDeadLetters answers the deliveries dead of every search, the most recent first
+func GetDeadLetters(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	respondJSON(w, http.StatusOK, deliveriesOf(func(current *delivery) bool { return current.Status == deliveryDead }))
+}
+
+// RetryDeadLetter delivers the dead delivery again, with the attempts starting over
+func RetryDeadLetter(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !openSearches(config, w, r) {
+		return
+	}
+	defer searches.mutex.Unlock()
+	logger := RequestLogger(config, r).WithField("deliveryId", mux.Vars(r)["id"])
+	dead := searches.find(mux.Vars(r)["id"])
+	if dead == nil || dead.Status != deliveryDead || searches.searches[dead.SearchId] == nil {
+		logger.Error("Dead letter not found")
+		respondError(w, http.StatusNotFound, "Dead letter not found.")
+		return
+	}
+	dead.Status = deliveryPending
+	dead.Attempts = 0
+	searches.schedule(config, dead, 0)
+	if !saveSearches(config, w, r) {
+		return
+	}
+	logger.Info("Dead letter retried")
+	respondJSON(w, http.StatusOK, &dead.Delivery)
+}
+
+func deliveriesOf(selected func(current *delivery) bool) *model.Deliveries {
+	response := model.Deliveries{Deliveries: []model.Delivery{}}
+	for i := len(searches.deliveries) - 1; i >= 0; i-- {
+		if selected(searches.deliveries[i]) {
+			response.Deliveries = append(response.Deliveries, searches.deliveries[i].Delivery)
+		}
+	}
+	return &response
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"time"
+
+	"gitlab.com/zap-api/app/model"
+)
+
+// snapshot is the processed list of Properties of a source, as it is kept in the cache
+// the indexes are built once, so every request can filter and count over them
+type snapshot struct {
+	Properties []model.Property
+	// Version is the time of the ingestion in nanoseconds, shared by the snapshots of every source
+	Version   int64
+	CreatedAt time.Time
+	index     *propertyIndex
+	text      *textIndex
+	// priceDropped marks the Properties whose last price change was a drop, priceChangedAt is the time of that change
+	priceDropped   bitset
+	priceChangedAt []time.Time
+	// upstreamUpdatedAt is the updatedAt of the Properties by their IDs, before the campaigns changed it
+	upstreamUpdatedAt map[string]upstreamTime
+}
+
+// upstreamTime is a time of the upstream as it was informed, and parsed once on the ingestion
+// the valid is false when it is not a time of RFC 3339
+type upstreamTime struct {
+	value string
+	time  time.Time
+	valid bool
+}
+
+func newUpstreamTime(value string) upstreamTime {
+	parsed, err := time.Parse(time.RFC3339, value)
+	return upstreamTime{value: value, time: parsed, valid: err == nil}
+}
+
+func newSnapshot(properties []model.Property, createdAt time.Time, upstreamUpdatedAt map[string]upstreamTime) *snapshot {
+	return &snapshot{
+		Properties:        properties,
+		Version:           createdAt.UnixNano(),
+		CreatedAt:         createdAt,
+		index:             newPropertyIndex(properties),
+		text:              newTextIndex(properties),
+		priceDropped:      newBitset(len(properties)),
+		priceChangedAt:    make([]time.Time, len(properties)),
+		upstreamUpdatedAt: upstreamUpdatedAt,
+	}
+}
+
+// selection returns the Properties with the positions set in selected, in the snapshot order
+func (s *snapshot) selection(selected bitset) []model.Property {
+	if selected.count() == len(s.Properties) {
+		return s.Properties
+	}
+	properties := make([]model.Property, 0, selected.count())
+	for i := range s.Properties {
+		if selected.has(i) {
+			properties = append(properties, s.Properties[i])
+		}
+	}
+	return properties
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// GetSources responds the sources configured, with their rules, campaigns and the Properties of their snapshots
+func GetSources(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range *config.Datasources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sources := model.Sources{Sources: []model.Source{}}
+	for _, name := range names {
+		source := model.Source{Name: name, Enabled: config.Enabled(name), Campaigns: []model.SourceCampaign{}}
+		if rules, ok := (*config.Rules)[name]; ok {
+			source.Rules = &model.SourceRules{
+				SaleMinPrice:              rules.SaleMinPrice,
+				RentalMinPrice:            rules.RentalMinPrice,
+				RentalMinSquareMeterPrice: rules.RentalMinSquareMeterPrice,
+				RentalMaxCondoFeeRatio:    rules.RentalMaxCondoFeeRatio,
+			}
+		}
+		quality := config.QualityOf(name)
+		source.Quality = &model.SourceQuality{
+			Images:     quality.Images,
+			Location:   quality.Location,
+			Pricing:    quality.Pricing,
+			Recency:    quality.Recency,
+			MaxImages:  quality.MaxImages,
+			StaleAfter: quality.StaleAfter.String(),
+		}
+		for _, campaign := range (*config.Campaigns)[name] {
+			sourceCampaign := model.SourceCampaign{
+				Zone:            campaign.Zone,
+				BusinessType:    campaign.BusinessType,
+				PriceMultiplier: campaign.PriceMultiplier,
+			}
+			if zone, ok := (*config.Zones)[campaign.Zone]; ok {
+				sourceCampaign.BoundingBox = model.Zone{MinLon: zone.MinLon, MinLat: zone.MinLat, MaxLon: zone.MaxLon, MaxLat: zone.MaxLat}
+			}
+			source.Campaigns = append(source.Campaigns, sourceCampaign)
+		}
+		if propertiesCached, found := config.Cache.Get(name); found {
+			source.Properties = len(propertiesCached.(*snapshot).Properties)
+		}
+		sources.Sources = append(sources.Sources, source)
+	}
+	respondJSON(w, http.StatusOK, &sources)
+}
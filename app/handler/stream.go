@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/config"
+)
+
+// streamEventTypes of the changes, the changes of the other fields are just answered by the /changes
+var streamEventTypes = map[string]string{
+	changeAdded:   "listing.added",
+	changeRemoved: "listing.removed",
+}
+
+// streamsClosing is closed when the server shuts down, so the streams end and the requests are drained
+var streamsClosing = struct {
+	sync.Mutex
+	closing chan struct{}
+}{closing: make(chan struct{})}
+
+// CloseStreams ends every stream open, the next ones are not affected
+func CloseStreams() {
+	streamsClosing.Lock()
+	defer streamsClosing.Unlock()
+	close(streamsClosing.closing)
+	streamsClosing.closing = make(chan struct{})
+}
+
+func closingStreams() <-chan struct{} {
+	streamsClosing.Lock()
+	defer streamsClosing.Unlock()
+	return streamsClosing.closing
+}
+
+// streamEvent returns the event of the change, or empty when the change is not streamed
+func streamEvent(change *model.Change) string {
+	if change.Type != changeChanged {
+		return streamEventTypes[change.Type]
+	}
+	if _, ok := change.Fields["pricingInfos.price"]; ok {
+		return "listing.price_changed"
+	}
+	return ""
+}
+
+// Stream pushes the changes of the source as Server-Sent Events on every new snapshot
+// the ID of the events is the snapshot version and the ID of the Property, the Last-Event-ID resumes after it
+func Stream(config *config.Config, w http.ResponseWriter, r *http.Request) {
+	source := mux.Vars(r)["source"]
+	logger := RequestLogger(config, r).WithField("source", source)
+	if !config.Enabled(source) {
+		logger.Error("No change found for the source")
+		respondError(w, http.StatusNotFound, "Source not accepted.")
+		return
+	}
+	if !sourceAllowed(r, source) {
+		logger.Error("Source not allowed for the client")
+		respondError(w, http.StatusForbidden, "Source not allowed.")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Error("Streaming not supported by the response")
+		respondError(w, http.StatusInternalServerError, "Streaming not supported.")
+		return
+	}
+	if getSnapshotOr404(config, source, w, r) == nil {
+		return
+	}
+	version, id := changes.lastVersion(source), ""
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		parts := strings.SplitN(lastEventID, "-", 2)
+		parsed, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			logger.WithField("lastEventId", lastEventID).Error("Invalid Last-Event-ID")
+			respondError(w, http.StatusBadRequest, "Invalid Last-Event-ID.")
+			return
+		}
+		version, id = parsed, parts[1]
+	}
+	if _, ok := changes.after(source, version, id); !ok {
+		logger.WithField("since", version).Error("Changes since the version are not kept")
+		respondError(w, http.StatusGone, "Changes since the version are not kept, export the source again.")
+		return
+	}
+	// The stream is open longer than the write timeout of the server, its deadline is cleared
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		logger.WithError(err).Warn("Write deadline of the stream not cleared")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// The proxies like nginx would buffer the events otherwise
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logger.Info("Stream opened")
+	heartbeat := time.NewTicker(config.Stream.Heartbeat)
+	defer heartbeat.Stop()
+	closing := closingStreams()
+	for {
+		updated := changes.wait()
+		pending, ok := changes.after(source, version, id)
+		if !ok {
+			logger.Warn("Stream behind the changes kept, closing it")
+			return
+		}
+		for i := range pending {
+			change := &pending[i]
+			version, id = change.SnapshotVersion, change.Id
+			event := streamEvent(change)
+			if event == "" {
+				continue
+			}
+			data, _ := json.Marshal(change)
+			if _, err := fmt.Fprintf(w, "id: %d-%s\nevent: %s\ndata: %s\n\n", version, id, event, data); err != nil {
+				logger.WithError(err).Info("Stream closed by the client")
+				return
+			}
+			streamEvents.WithLabelValues(source, event).Inc()
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			logger.Info("Stream closed by the client")
+			return
+		case <-closing:
+			logger.Info("Stream closed by the shutdown")
+			return
+		case <-updated:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
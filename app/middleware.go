@@ -0,0 +1,145 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab.com/zap-api/app/handler"
+	"gitlab.com/zap-api/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{Name: "zap_http_requests_total",
+		Help: "Requests answered by the API, by route, source and status."}, []string{"route", "source", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: "zap_http_request_duration_seconds",
+		Help: "Latency of the requests answered by the API, by route, source and status.", Buckets: handler.LatencyBuckets}, []string{"route", "source", "status"})
+)
+
+// responseRecorder keeps the status written by the handlers
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush lets the handlers stream through the recorder
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets the http.ResponseController reach the connection through the recorder
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument counts the requests and observes their latency, the sources not accepted are labeled as unknown
+func (a *App) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if path, err := current.GetPathTemplate(); err == nil {
+				route = path
+			}
+		}
+		source := r.Header.Get("source")
+		if !a.currentConfig().Enabled(source) {
+			source = "unknown"
+		}
+		status := strconv.Itoa(recorder.status)
+		httpRequests.WithLabelValues(route, source, status).Inc()
+		httpDuration.WithLabelValues(route, source, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// requestID takes the ID of the request from the X-Request-ID header, or generates it, and answers it back
+func (a *App) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, id := handler.WithRequestID(r)
+		w.Header().Set(handler.RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trace starts the Span of the request, continuing the trace of the client when it sends the traceparent header
+func (a *App) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if path, err := current.GetPathTemplate(); err == nil {
+				route = path
+			}
+		}
+		ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer))
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.request_id", handler.RequestID(r)),
+		)
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			tracing.SetError(span, fmt.Errorf("status %d", recorder.status))
+		}
+		span.End()
+	})
+}
+
+// validate responds 400 for the query and header parameters not matching the OpenAPI document of the current configuration
+func (a *App) validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler.ValidateRequest(a.currentConfig(), a.currentDocument(), w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authenticate requires the API key of a client allowed to read the source, when the keys file is configured
+func (a *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r = handler.Authenticate(a.currentConfig(), w, r); r != nil {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authorizeAdmin requires the API key of an admin client, the administration is closed without the keys file
+func (a *App) authorizeAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r = handler.AuthorizeAdmin(a.currentConfig(), w, r); r != nil {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rateLimit responds 429 for the clients above their limits or quotas
+func (a *App) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler.RateLimit(a.currentConfig(), w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
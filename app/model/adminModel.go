@@ -0,0 +1,101 @@
+package model
+
+// LogLevel of the Logger, like "info" or "debug"
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// Readiness of the API, it is ready when every source is
+type Readiness struct {
+	Ready   bool                    `json:"ready"`
+	Sources map[string]SourceStatus `json:"sources"`
+}
+
+// SourceStatus tells if the snapshot of the source is loaded, and how old it is
+type SourceStatus struct {
+	Ready           bool    `json:"ready"`
+	Status          string  `json:"status"`
+	SnapshotVersion int64   `json:"snapshotVersion,omitempty"`
+	LoadedAt        string  `json:"loadedAt,omitempty"`
+	AgeSeconds      float64 `json:"ageSeconds,omitempty"`
+	Properties      int     `json:"properties"`
+	LastError       string  `json:"lastError,omitempty"`
+	LastErrorAt     string  `json:"lastErrorAt,omitempty"`
+}
+
+// ConfigStatus tells when the configuration was loaded and the errors of the last reload rejected
+type ConfigStatus struct {
+	File         string   `json:"file,omitempty"`
+	LoadedAt     string   `json:"loadedAt"`
+	Reloads      int      `json:"reloads"`
+	LastReloadAt string   `json:"lastReloadAt,omitempty"`
+	LastErrorAt  string   `json:"lastErrorAt,omitempty"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// Sources configured, the disabled ones included
+type Sources struct {
+	Sources []Source `json:"sources"`
+}
+
+// Source is a portal, with the rules accepting its Properties and the campaigns changing their prices
+type Source struct {
+	Name       string           `json:"name"`
+	Enabled    bool             `json:"enabled"`
+	Rules      *SourceRules     `json:"rules,omitempty"`
+	Quality    *SourceQuality   `json:"quality"`
+	Campaigns  []SourceCampaign `json:"campaigns"`
+	Properties int              `json:"properties"`
+}
+
+// SourceRules are the minimum prices and ratios of the Properties accepted, zero disables the optional ones
+type SourceRules struct {
+	SaleMinPrice              int     `json:"saleMinPrice"`
+	RentalMinPrice            int     `json:"rentalMinPrice"`
+	RentalMinSquareMeterPrice int     `json:"rentalMinSquareMeterPrice"`
+	RentalMaxCondoFeeRatio    float64 `json:"rentalMaxCondoFeeRatio"`
+}
+
+// SourceQuality are the weights of the parts of the quality score of the Properties
+type SourceQuality struct {
+	Images     float64 `json:"images"`
+	Location   float64 `json:"location"`
+	Pricing    float64 `json:"pricing"`
+	Recency    float64 `json:"recency"`
+	MaxImages  int     `json:"maxImages"`
+	StaleAfter string  `json:"staleAfter"`
+}
+
+// SourceCampaign multiplies the prices of a business type inside the zone
+type SourceCampaign struct {
+	Zone            string  `json:"zone"`
+	BoundingBox     Zone    `json:"boundingBox"`
+	BusinessType    string  `json:"businessType"`
+	PriceMultiplier float64 `json:"priceMultiplier"`
+}
+
+// Zone is the bounding box of a campaign
+type Zone struct {
+	MinLon float64 `json:"minLon"`
+	MinLat float64 `json:"minLat"`
+	MaxLon float64 `json:"maxLon"`
+	MaxLat float64 `json:"maxLat"`
+}
+
+// Quotas has the requests of the UTC day of every client, by its API key or its IP
+type Quotas struct {
+	Day     string        `json:"day"`
+	Clients []ClientQuota `json:"clients"`
+}
+
+// ClientQuota is the count of the requests of the client in the day, the zero Quota is unlimited
+type ClientQuota struct {
+	Client   string `json:"client"`
+	Requests int    `json:"requests"`
+	Quota    int    `json:"quota"`
+}
+
+// Quarantine has the Properties of every source kept out of the last snapshot by their anomalies
+type Quarantine struct {
+	Sources map[string][]PropertyV2 `json:"sources"`
+}
@@ -0,0 +1,26 @@
+package model
+
+// ChangesResponse has the changes of a source after the Since version, the SnapshotVersion is the next Since
+type ChangesResponse struct {
+	Source            string   `json:"source"`
+	Since             int64    `json:"since"`
+	SnapshotVersion   int64    `json:"snapshotVersion"`
+	Changes           []Change `json:"changes"`
+	PageNumber        int      `json:"pageNumber"`
+	PageSize          int      `json:"pageSize"`
+	ChangesTotalCount int      `json:"changesTotalCount"`
+}
+
+// Change of a Property between two snapshots, the Fields are just informed for the changed ones
+type Change struct {
+	SnapshotVersion int64                  `json:"snapshotVersion"`
+	Type            string                 `json:"type"`
+	Id              string                 `json:"id"`
+	Fields          map[string]FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange has the values of the field in the previous and in the new snapshot
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
@@ -0,0 +1,28 @@
+package model
+
+// ExportRequest starts an export job of the source, the Filters are the query parameters of the /properties
+type ExportRequest struct {
+	Source  string            `json:"source"`
+	Format  string            `json:"format"`
+	Columns []string          `json:"columns,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"`
+}
+
+// ExportJob is the status of an export, the artifact is downloaded from the Download path while it is not expired
+type ExportJob struct {
+	Id              string            `json:"id"`
+	Status          string            `json:"status"`
+	Source          string            `json:"source"`
+	Format          string            `json:"format"`
+	Columns         []string          `json:"columns,omitempty"`
+	Filters         map[string]string `json:"filters,omitempty"`
+	SnapshotVersion int64             `json:"snapshotVersion"`
+	Properties      int               `json:"properties"`
+	Size            int64             `json:"size"`
+	Error           string            `json:"error,omitempty"`
+	CreatedAt       string            `json:"createdAt"`
+	StartedAt       string            `json:"startedAt,omitempty"`
+	FinishedAt      string            `json:"finishedAt,omitempty"`
+	ExpiresAt       string            `json:"expiresAt,omitempty"`
+	Download        string            `json:"download,omitempty"`
+}
@@ -0,0 +1,17 @@
+package model
+
+// PricePoint is a price observed for a Property, a new point is kept only when the price changes
+// the Price is the one of the upstream, the AdjustedPrice is the one after the campaigns of the source
+type PricePoint struct {
+	ObservedAt      string `json:"observedAt"`
+	SnapshotVersion int64  `json:"snapshotVersion"`
+	Price           *Money `json:"price"`
+	AdjustedPrice   *Money `json:"adjustedPrice"`
+}
+
+// PriceHistory of a Property in a source, the oldest price first
+type PriceHistory struct {
+	Id     string       `json:"id"`
+	Source string       `json:"source"`
+	Prices []PricePoint `json:"prices"`
+}
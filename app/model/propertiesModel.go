@@ -15,6 +15,19 @@ type Property struct {
 	Bathrooms     int          `json:"bathrooms"`
 	Bedrooms      int          `json:"bedrooms"`
 	PricingInfos  PricingInfos `json:"pricingInfos"`
+	// DuplicateGroupId is shared by the probable duplicates of the source, it is empty for the unique ones, just the v2 answers it
+	DuplicateGroupId string `json:"-"`
+	// QualityScore from 0 to 100 is computed on the ingestion with the weights of the source, just the v2 answers it
+	QualityScore float64 `json:"-"`
+	// Anomalies of the prices found on the ingestion, empty for the usual ones, just the v2 answers them
+	Anomalies []Anomaly `json:"-"`
+}
+
+// Anomaly of the prices of a Property, the Score is the robust z-score of the outliers of the price per square meter
+type Anomaly struct {
+	Reason   string  `json:"reason"`
+	Severity string  `json:"severity"`
+	Score    float64 `json:"score,omitempty"`
 }
 
 type Address struct {
@@ -43,22 +56,27 @@ type PricingInfos struct {
 }
 
 type ListPropertyResponse struct {
-	Properties           []Property `json:"listings"`
-	PageNumber           int        `json:"pageNumber"`
-	PageSize             int        `json:"pageSize"`
-	PropertiesTotalCount int        `json:"propertiestotalCount"`
+	Properties           []Property              `json:"listings"`
+	PageNumber           int                     `json:"pageNumber"`
+	PageSize             int                     `json:"pageSize"`
+	PropertiesTotalCount int                     `json:"propertiestotalCount"`
+	Facets               map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// FacetCount is how many Properties of the filtered result are in a bucket of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
 }
 
-type BoundingBox struct {
-	Minlon float64
-	Minlat float64
-	Maxlon float64
-	Maxlat float64
+type AutocompleteResponse struct {
+	Suggestions []Suggestion `json:"suggestions"`
 }
 
-var VivaRealBoundBox = BoundingBox{
-	Minlon: -46.693419,
-	Minlat: -23.568704,
-	Maxlon: -46.641146,
-	Maxlat: -23.546686,
+// Suggestion is a City or Neighborhood found by the autocomplete, with the Properties count of every source
+type Suggestion struct {
+	Text       string         `json:"text"`
+	Type       string         `json:"type"`
+	Counts     map[string]int `json:"counts"`
+	TotalCount int            `json:"totalCount"`
 }
@@ -0,0 +1,50 @@
+package model
+
+// Currency of every Money value, the portals just list properties in Brazil
+const Currency = "BRL"
+
+// Money is an amount in cents, so it is never rounded again after it is parsed
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// PropertyV2 data model with typed money fields
+type PropertyV2 struct {
+	UsableAreas         int            `json:"usableAreas"`
+	ListingType         string         `json:"listingType"`
+	CreatedAt           string         `json:"createdAt"`
+	ListingStatus       string         `json:"listingStatus"`
+	Id                  string         `json:"id"`
+	ParkingSpaces       int            `json:"parkingSpaces"`
+	UpdatedAt           string         `json:"updatedAt"`
+	Owner               bool           `json:"owner"`
+	Images              []string       `json:"images"`
+	Address             Address        `json:"address"`
+	Bathrooms           int            `json:"bathrooms"`
+	Bedrooms            int            `json:"bedrooms"`
+	PricingInfos        PricingInfosV2 `json:"pricingInfos"`
+	PricePerSquareMeter *Money         `json:"pricePerSquareMeter,omitempty"`
+	TotalMonthlyCost    *Money         `json:"totalMonthlyCost,omitempty"`
+	DuplicateGroupId    string         `json:"duplicateGroupId,omitempty"`
+	QualityScore        float64        `json:"qualityScore"`
+	Anomalies           []Anomaly      `json:"anomalies,omitempty"`
+}
+
+// PricingInfosV2 keeps the optional fees as nil when the source does not inform them
+type PricingInfosV2 struct {
+	YearlyIptu       *Money `json:"yearlyIptu,omitempty"`
+	Price            *Money `json:"price,omitempty"`
+	BusinessType     string `json:"businessType"`
+	MonthlyCondoFee  *Money `json:"monthlyCondoFee,omitempty"`
+	Period           string `json:"period,omitempty"`
+	RentalTotalPrice *Money `json:"rentalTotalPrice,omitempty"`
+}
+
+type ListPropertyResponseV2 struct {
+	Properties           []PropertyV2            `json:"listings"`
+	PageNumber           int                     `json:"pageNumber"`
+	PageSize             int                     `json:"pageSize"`
+	PropertiesTotalCount int                     `json:"propertiesTotalCount"`
+	Facets               map[string][]FacetCount `json:"facets,omitempty"`
+}
@@ -0,0 +1,57 @@
+package model
+
+// SavedSearch of a client, the new Properties matching it after an ingestion are sent to the WebhookURL
+// the Secret signs the webhooks, it is just answered on the creation
+type SavedSearch struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Source     string            `json:"source"`
+	Filters    map[string]string `json:"filters,omitempty"`
+	Radius     *GeoRadius        `json:"radius,omitempty"`
+	WebhookURL string            `json:"webhookUrl"`
+	Secret     string            `json:"secret,omitempty"`
+	CreatedAt  string            `json:"createdAt"`
+	UpdatedAt  string            `json:"updatedAt"`
+}
+
+// GeoRadius is the circle around a point where the Properties must be
+type GeoRadius struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Meters float64 `json:"meters"`
+}
+
+// SavedSearches of the client
+type SavedSearches struct {
+	Searches []SavedSearch `json:"searches"`
+}
+
+// Delivery of a webhook, it is pending while it is retried, then delivered or dead
+type Delivery struct {
+	Id              string   `json:"id"`
+	SearchId        string   `json:"searchId"`
+	Status          string   `json:"status"`
+	SnapshotVersion int64    `json:"snapshotVersion"`
+	Listings        []string `json:"listings"`
+	Attempts        int      `json:"attempts"`
+	CreatedAt       string   `json:"createdAt"`
+	LastAttemptAt   string   `json:"lastAttemptAt,omitempty"`
+	NextAttemptAt   string   `json:"nextAttemptAt,omitempty"`
+	LastStatusCode  int      `json:"lastStatusCode,omitempty"`
+	LastError       string   `json:"lastError,omitempty"`
+	DeliveredAt     string   `json:"deliveredAt,omitempty"`
+}
+
+// Deliveries of the webhooks, the most recent first
+type Deliveries struct {
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// SearchMatch is the body of the webhooks, with the new Properties matching the search
+type SearchMatch struct {
+	DeliveryId      string       `json:"deliveryId"`
+	SearchId        string       `json:"searchId"`
+	Source          string       `json:"source"`
+	SnapshotVersion int64        `json:"snapshotVersion"`
+	Listings        []PropertyV2 `json:"listings"`
+}
@@ -0,0 +1,237 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Document is the OpenAPI 3 description of the API, just with the parts the API uses
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem has the Operation of every method, keyed by the lower case method like "get"
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string                `json:"summary"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	Name   string `json:"name,omitempty"`
+	In     string `json:"in,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Operation returns the Operation for the path template and method, or nil when it is not documented
+func (d *Document) Operation(path, method string) *Operation {
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// resolve follows the $ref of the Schema to the components
+func (d *Document) resolve(schema *Schema) (*Schema, error) {
+	if schema.Ref == "" {
+		return schema, nil
+	}
+	name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+	resolved, ok := d.Components.Schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("schema %s is not defined", schema.Ref)
+	}
+	return d.resolve(resolved)
+}
+
+// ValidateParameter checks the value received for the query Parameter against its Schema
+func (d *Document) ValidateParameter(parameter *Parameter, value string) error {
+	schema, err := d.resolve(parameter.Schema)
+	if err != nil {
+		return err
+	}
+	var number float64
+	switch schema.Type {
+	case "integer":
+		integer, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parameter %s must be an integer", parameter.Name)
+		}
+		number = float64(integer)
+	case "number":
+		if number, err = strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("parameter %s must be a number", parameter.Name)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("parameter %s must be a boolean", parameter.Name)
+		}
+	}
+	if schema.Minimum != nil && number < *schema.Minimum {
+		return fmt.Errorf("parameter %s must be at least %v", parameter.Name, *schema.Minimum)
+	}
+	if schema.Maximum != nil && number > *schema.Maximum {
+		return fmt.Errorf("parameter %s must be at most %v", parameter.Name, *schema.Maximum)
+	}
+	if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+		return fmt.Errorf("parameter %s must be one of %s", parameter.Name, strings.Join(schema.Enum, ", "))
+	}
+	return nil
+}
+
+// ValidateResponse checks the JSON body answered by the Operation for the status against its Schema
+func (d *Document) ValidateResponse(operation *Operation, status int, body []byte) error {
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+	if len(response.Content) == 0 && len(body) == 0 {
+		return nil
+	}
+	media, ok := response.Content["application/json"]
+	if !ok {
+		return fmt.Errorf("status %d has no JSON content documented", status)
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return err
+	}
+	return d.validateValue("body", value, media.Schema)
+}
+
+// validateValue checks a decoded JSON value, the properties not documented are also errors,
+// so a field added to the models without the specification fails the validation
+func (d *Document) validateValue(path string, value interface{}, schema *Schema) error {
+	schema, err := d.resolve(schema)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "array" || schema.Type == "object" {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", path)
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			if property == nil {
+				return fmt.Errorf("%s.%s is not documented", path, name)
+			}
+			if err := d.validateValue(path+"."+name, object[name], property); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		for i, item := range items {
+			if err := d.validateValue(path+"["+strconv.Itoa(i)+"]", item, schema.Items); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
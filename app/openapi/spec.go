@@ -0,0 +1,719 @@
+package openapi
+
+import (
+	"strings"
+
+	"gitlab.com/zap-api/config"
+)
+
+// NewDocument describes the routes of the API, the sources are the values accepted in the source header
+// the secured document requires the API keys on the routes of the Properties and of the administration,
+// the administration of the document not secured answers just 403
+// and the routes of the versions deprecated by the configuration are deprecated, like their headers
+func NewDocument(sources []string, secured bool, versions map[string]*config.Version) *Document {
+	document := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:       "ZAP API",
+			Description: "Properties of the portals, like ZAP and VivaReal",
+			Version:     "2",
+		},
+		Paths: map[string]PathItem{
+			"/openapi.json": {"get": &Operation{
+				Summary: "This document",
+				Responses: map[string]*Response{
+					"200": {Description: "The OpenAPI document"},
+				},
+			}},
+			"/admin/log-level": {
+				"get": &Operation{
+					Summary: "Level of the Logger",
+					Responses: map[string]*Response{
+						"200": jsonResponse("Current level", "LogLevel"),
+					},
+				},
+				"put": &Operation{
+					Summary: "Changes the level of the Logger",
+					Responses: map[string]*Response{
+						"200": jsonResponse("New level", "LogLevel"),
+						"400": jsonResponse("Level not accepted", "Error"),
+					},
+				},
+			},
+			"/admin/config": {"get": &Operation{
+				Summary: "Status of the reloads of the configuration",
+				Responses: map[string]*Response{
+					"200": jsonResponse("When it was loaded and the errors of the last reload rejected", "ConfigStatus"),
+				},
+			}},
+			"/admin/sources": {"get": &Operation{
+				Summary: "Sources configured, with their rules and campaigns",
+				Responses: map[string]*Response{
+					"200": jsonResponse("Every source, the disabled ones included", "Sources"),
+				},
+			}},
+			"/admin/quotas": {"get": &Operation{
+				Summary: "Requests of the day of every client",
+				Responses: map[string]*Response{
+					"200": jsonResponse("Requests and quotas of the clients", "Quotas"),
+				},
+			}},
+			"/admin/quarantine": {"get": &Operation{
+				Summary: "Properties of every source kept out of the last snapshot by their anomalies",
+				Responses: map[string]*Response{
+					"200": jsonResponse("Properties in quarantine by source", "Quarantine"),
+				},
+			}},
+			"/admin/dead-letters": {"get": &Operation{
+				Summary: "Webhooks of the saved searches dead after the last attempt",
+				Responses: map[string]*Response{
+					"200": jsonResponse("Dead deliveries, the most recent first", "Deliveries"),
+				},
+			}},
+			"/admin/dead-letters/{id}/retry": {"post": &Operation{
+				Summary:    "Delivers the dead webhook again, with the attempts starting over",
+				Parameters: []*Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}}},
+				Responses: map[string]*Response{
+					"200": jsonResponse("Delivery pending again", "Delivery"),
+					"404": jsonResponse("Dead letter not found", "Error"),
+				},
+			}},
+			"/healthz": {"get": &Operation{
+				Summary: "Liveness",
+				Responses: map[string]*Response{
+					"200": jsonResponse("The API is alive", "Health"),
+				},
+			}},
+			"/readyz": {"get": &Operation{
+				Summary: "Readiness, with the status of the snapshot of every source",
+				Responses: map[string]*Response{
+					"200": jsonResponse("Every source has a recent snapshot", "Readiness"),
+					"503": jsonResponse("Some source is loading or stale", "Readiness"),
+				},
+			}},
+			"/metrics": {"get": &Operation{
+				Summary: "Metrics in the Prometheus text format",
+				Responses: map[string]*Response{
+					"200": {Description: "Requests, cache, ingestion and runtime metrics"},
+				},
+			}},
+		},
+		Components: Components{Schemas: schemas()},
+	}
+	for _, version := range []struct {
+		prefix     string
+		listing    string
+		property   string
+		deprecated bool
+	}{
+		{"", "ListPropertyResponse", "Property", deprecated(versions, "v1")},
+		{"/v1", "ListPropertyResponse", "Property", deprecated(versions, "v1")},
+		{"/v2", "ListPropertyResponseV2", "PropertyV2", deprecated(versions, "v2")},
+	} {
+		document.Paths[version.prefix+"/properties"] = PathItem{"get": &Operation{
+			Summary:    "Properties of the source, filtered and paginated",
+			Deprecated: version.deprecated,
+			Parameters: propertiesParameters(sources, secured),
+			Responses: map[string]*Response{
+				"200": jsonResponse("Page of the Properties", version.listing),
+				"304": {Description: "Page not modified since the ETag or the Last-Modified of the client"},
+				"400": jsonResponse("Invalid parameter", "Error"),
+				"404": jsonResponse("Source not accepted or Properties not found", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/properties/{id}/history"] = PathItem{"get": &Operation{
+			Summary:    "Prices observed for the Property, upstream and after the campaigns of the source",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{
+				{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+				{Name: "source", In: "header", Required: !secured, Description: "Portal of the Properties, the first source of the API key when absent", Schema: &Schema{Type: "string", Enum: sources}},
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Prices of the Property, the oldest first", "PriceHistory"),
+				"404": jsonResponse("Source not accepted or Property not found", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/export/{source}"] = PathItem{"get": &Operation{
+			Summary:    "Every Property of the source accepted by the filters, streamed without pagination",
+			Deprecated: version.deprecated,
+			Parameters: exportParameters(sources),
+			Responses: map[string]*Response{
+				"200": {
+					Description: "Properties by line in NDJSON, rows of the columns in CSV or a JSON array",
+					Content: map[string]MediaType{
+						"application/x-ndjson": {Schema: ref(version.property)},
+						"text/csv":             {Schema: &Schema{Type: "string"}},
+						"application/json":     {Schema: arrayOf(version.property)},
+					},
+				},
+				"400": jsonResponse("Invalid parameter or column", "Error"),
+				"404": jsonResponse("Source not accepted or Properties not found", "Error"),
+			},
+		}}
+		exportID := &Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		document.Paths[version.prefix+"/exports"] = PathItem{"post": &Operation{
+			Summary:    "Starts a job writing the Properties of the source to a gzip artifact",
+			Deprecated: version.deprecated,
+			RequestBody: &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: ref("ExportRequest")}},
+			},
+			Responses: map[string]*Response{
+				"202": jsonResponse("Job queued, polled in the Location", "ExportJob"),
+				"400": jsonResponse("Invalid body, source, format, column or filter", "Error"),
+				"404": jsonResponse("Properties not found", "Error"),
+				"503": jsonResponse("Queue of the exports full, retried after the Retry-After", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/exports/{id}"] = PathItem{
+			"get": &Operation{
+				Summary:    "Status of the export job",
+				Deprecated: version.deprecated,
+				Parameters: []*Parameter{exportID},
+				Responses: map[string]*Response{
+					"200": jsonResponse("Job, with the download path when it succeeded", "ExportJob"),
+					"404": jsonResponse("Job not found or expired", "Error"),
+				},
+			},
+			"delete": &Operation{
+				Summary:    "Cancels the export job queued or running",
+				Deprecated: version.deprecated,
+				Parameters: []*Parameter{exportID},
+				Responses: map[string]*Response{
+					"200": jsonResponse("Job cancelled", "ExportJob"),
+					"404": jsonResponse("Job not found or expired", "Error"),
+					"409": jsonResponse("Job already finished", "Error"),
+				},
+			},
+		}
+		document.Paths[version.prefix+"/exports/{id}/download"] = PathItem{"get": &Operation{
+			Summary:    "Artifact of the export job succeeded",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{exportID},
+			Responses: map[string]*Response{
+				"200": {
+					Description: "Gzip of the NDJSON, CSV or GeoJSON",
+					Content:     map[string]MediaType{"application/gzip": {Schema: &Schema{Type: "string", Format: "binary"}}},
+				},
+				"206": {Description: "Range of the artifact"},
+				"304": {Description: "Artifact not modified"},
+				"404": jsonResponse("Job or artifact not found or expired", "Error"),
+				"409": jsonResponse("Job not succeeded", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/changes"] = PathItem{"get": &Operation{
+			Summary:    "Properties added, removed and changed between the snapshots of the source",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{
+				{Name: "source", In: "query", Required: true, Description: "Portal of the Properties", Schema: &Schema{Type: "string", Enum: sources}},
+				{Name: "since", In: "query", Description: "Snapshot version of the last changes read, every change kept when absent", Schema: &Schema{Type: "integer", Minimum: number(0)}},
+				{Name: "offset", In: "query", Description: "Page number, starting at 0", Schema: &Schema{Type: "integer", Minimum: number(0), Maximum: number(maxPageNumber)}},
+				{Name: "limit", In: "query", Description: "Page size, 10 by default and capped by the maximum page size", Schema: &Schema{Type: "integer", Minimum: number(1)}},
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Page of the changes, in the order of the snapshots", "ChangesResponse"),
+				"400": jsonResponse("Invalid parameter", "Error"),
+				"404": jsonResponse("Source not accepted or Properties not found", "Error"),
+				"410": jsonResponse("Changes since the version not kept anymore", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/stream/{source}"] = PathItem{"get": &Operation{
+			Summary:    "Server-Sent Events of the Properties added, removed and with the price changed, on every new snapshot",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{
+				{Name: "source", In: "path", Required: true, Description: "Portal of the Properties", Schema: &Schema{Type: "string", Enum: sources}},
+				{Name: "Last-Event-ID", In: "header", Description: "ID of the last event received, the stream resumes after it", Schema: &Schema{Type: "string"}},
+			},
+			Responses: map[string]*Response{
+				"200": {
+					Description: "Events listing.added, listing.removed and listing.price_changed with the Change in the data",
+					Content:     map[string]MediaType{"text/event-stream": {Schema: &Schema{Type: "string"}}},
+				},
+				"400": jsonResponse("Invalid Last-Event-ID", "Error"),
+				"404": jsonResponse("Source not accepted or Properties not found", "Error"),
+				"410": jsonResponse("Changes after the Last-Event-ID not kept anymore", "Error"),
+			},
+		}}
+		searchID := &Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		searchBody := &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: ref("SavedSearch")}},
+		}
+		document.Paths[version.prefix+"/searches"] = PathItem{
+			"post": &Operation{
+				Summary:     "Saves a search, the new Properties matching it after the ingestions are sent to its webhook",
+				Deprecated:  version.deprecated,
+				RequestBody: searchBody,
+				Responses: map[string]*Response{
+					"201": jsonResponse("Search saved, with the secret of the signatures of its webhooks", "SavedSearch"),
+					"400": jsonResponse("Invalid body, source, filter, radius or webhook URL", "Error"),
+					"500": jsonResponse("Saved searches not available", "Error"),
+				},
+			},
+			"get": &Operation{
+				Summary:    "Searches saved by the client",
+				Deprecated: version.deprecated,
+				Responses: map[string]*Response{
+					"200": jsonResponse("Searches in the order of creation", "SavedSearches"),
+					"500": jsonResponse("Saved searches not available", "Error"),
+				},
+			},
+		}
+		document.Paths[version.prefix+"/searches/{id}"] = PathItem{
+			"get": &Operation{
+				Summary:    "Search saved by the client",
+				Deprecated: version.deprecated,
+				Parameters: []*Parameter{searchID},
+				Responses: map[string]*Response{
+					"200": jsonResponse("Search, without the secret", "SavedSearch"),
+					"404": jsonResponse("Search not found", "Error"),
+					"500": jsonResponse("Saved searches not available", "Error"),
+				},
+			},
+			"put": &Operation{
+				Summary:     "Replaces the search, its secret is kept",
+				Deprecated:  version.deprecated,
+				Parameters:  []*Parameter{searchID},
+				RequestBody: searchBody,
+				Responses: map[string]*Response{
+					"200": jsonResponse("Search replaced, without the secret", "SavedSearch"),
+					"400": jsonResponse("Invalid body, source, filter, radius or webhook URL", "Error"),
+					"404": jsonResponse("Search not found", "Error"),
+					"500": jsonResponse("Saved searches not available", "Error"),
+				},
+			},
+			"delete": &Operation{
+				Summary:    "Removes the search with its webhooks pending",
+				Deprecated: version.deprecated,
+				Parameters: []*Parameter{searchID},
+				Responses: map[string]*Response{
+					"204": {Description: "Search removed"},
+					"404": jsonResponse("Search not found", "Error"),
+					"500": jsonResponse("Saved searches not available", "Error"),
+				},
+			},
+		}
+		document.Paths[version.prefix+"/searches/{id}/deliveries"] = PathItem{"get": &Operation{
+			Summary:    "History of the webhooks of the search",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{searchID},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Deliveries, the most recent first", "Deliveries"),
+				"404": jsonResponse("Search not found", "Error"),
+				"500": jsonResponse("Saved searches not available", "Error"),
+			},
+		}}
+		document.Paths[version.prefix+"/autocomplete"] = PathItem{"get": &Operation{
+			Summary:    "Cities and Neighborhoods starting with the query",
+			Deprecated: version.deprecated,
+			Parameters: []*Parameter{
+				{Name: "q", In: "query", Required: true, Description: "Start of the City or Neighborhood, accents are ignored", Schema: &Schema{Type: "string"}},
+				{Name: "limit", In: "query", Description: "Maximum of suggestions, 10 by default", Schema: &Schema{Type: "integer", Minimum: number(1)}},
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("Suggestions ranked by relevance", "AutocompleteResponse"),
+				"400": jsonResponse("Invalid parameter", "Error"),
+				"404": jsonResponse("Properties not found", "Error"),
+			},
+		}}
+	}
+	for path, item := range document.Paths {
+		if publicPaths[path] {
+			continue
+		}
+		for _, operation := range item {
+			operation.Responses["429"] = jsonResponse("Rate limit or daily quota of the client exceeded", "Error")
+		}
+	}
+	if secured {
+		secure(document)
+		return document
+	}
+	for path, item := range document.Paths {
+		if !strings.HasPrefix(path, "/admin/") {
+			continue
+		}
+		for _, operation := range item {
+			operation.Responses["403"] = jsonResponse("Administration not allowed without the API keys", "Error")
+		}
+	}
+	return document
+}
+
+// maxPageNumber bounds the offset, a page past it is never reached by the page sizes accepted
+const maxPageNumber = 1000000
+
+// publicPaths are answered without the API keys and the rate limits
+var publicPaths = map[string]bool{
+	"/openapi.json": true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/metrics":      true,
+}
+
+// deprecated tells if the version is deprecated by the configuration, the versions not configured are not
+func deprecated(versions map[string]*config.Version, name string) bool {
+	version, ok := versions[name]
+	return ok && version.Deprecated
+}
+
+// secure requires the API key, by the Authorization Bearer or by the X-API-Key header, on every path not public
+func secure(document *Document) {
+	document.Components.SecuritySchemes = map[string]*SecurityScheme{
+		"bearer": {Type: "http", Scheme: "bearer"},
+		"apiKey": {Type: "apiKey", Name: "X-API-Key", In: "header"},
+	}
+	for path, item := range document.Paths {
+		if publicPaths[path] {
+			continue
+		}
+		for _, operation := range item {
+			operation.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+			operation.Responses["401"] = jsonResponse("API key not informed or not accepted", "Error")
+			operation.Responses["403"] = jsonResponse("Source or administration not allowed for the client", "Error")
+		}
+	}
+}
+
+func propertiesParameters(sources []string, secured bool) []*Parameter {
+	parameters := []*Parameter{
+		{Name: "source", In: "header", Required: !secured, Description: "Portal of the Properties, the first source of the API key when absent", Schema: &Schema{Type: "string", Enum: sources}},
+		{Name: "offset", In: "query", Description: "Page number, starting at 0", Schema: &Schema{Type: "integer", Minimum: number(0), Maximum: number(maxPageNumber)}},
+		{Name: "limit", In: "query", Description: "Page size, 10 by default and capped by the maximum page size", Schema: &Schema{Type: "integer", Minimum: number(1)}},
+	}
+	parameters = append(parameters, filterParameters()...)
+	return append(parameters,
+		&Parameter{Name: "facets", In: "query", Description: "Comma separated bedrooms, bathrooms, neighborhood, businessType and priceRange", Schema: &Schema{Type: "string"}},
+		&Parameter{Name: "sort", In: "query", Description: "relevance by default, the best qualityScore first, or newest by the upstream updatedAt", Schema: &Schema{Type: "string", Enum: []string{"relevance", "newest"}}},
+	)
+}
+
+// filterParameters select the Properties, they are shared by the listing and the export
+func filterParameters() []*Parameter {
+	return []*Parameter{
+		{Name: "q", In: "query", Description: "Words of the City or Neighborhood, accents are ignored", Schema: &Schema{Type: "string"}},
+		{Name: "bedrooms", In: "query", Schema: &Schema{Type: "integer", Minimum: number(0)}},
+		{Name: "bathrooms", In: "query", Schema: &Schema{Type: "integer", Minimum: number(0)}},
+		{Name: "neighborhood", In: "query", Schema: &Schema{Type: "string"}},
+		{Name: "businessType", In: "query", Schema: &Schema{Type: "string", Enum: []string{"SALE", "RENTAL"}}},
+		{Name: "priceRange", In: "query", Description: "Bucket of the priceRange facet, like 600000-1000000", Schema: &Schema{Type: "string"}},
+		{Name: "minPrice", In: "query", Schema: &Schema{Type: "number", Minimum: number(0)}},
+		{Name: "maxPrice", In: "query", Schema: &Schema{Type: "number", Minimum: number(0)}},
+		{Name: "priceDropped", In: "query", Description: "Whether the last change of the price was a drop", Schema: &Schema{Type: "boolean"}},
+		{Name: "priceChangedSince", In: "query", Description: "Time of RFC 3339, like 2019-01-31T12:00:00Z, or date, like 2019-01-31, the price changed after it", Schema: &Schema{Type: "string"}},
+		{Name: "anomalies", In: "query", Description: "Whether the price has an anomaly", Schema: &Schema{Type: "boolean"}},
+		{Name: "collapseDuplicates", In: "query", Description: "Just the first Property of every group of probable duplicates", Schema: &Schema{Type: "boolean"}},
+	}
+}
+
+func exportParameters(sources []string) []*Parameter {
+	parameters := []*Parameter{
+		{Name: "source", In: "path", Required: true, Description: "Portal of the Properties", Schema: &Schema{Type: "string", Enum: sources}},
+		{Name: "format", In: "query", Description: "ndjson by default, json is a single array", Schema: &Schema{Type: "string", Enum: []string{"ndjson", "csv", "json"}}},
+		{Name: "columns", In: "query", Description: "Comma separated JSON paths of the CSV columns, like address.city, the configured columns by default", Schema: &Schema{Type: "string"}},
+	}
+	return append(parameters, filterParameters()...)
+}
+
+func schemas() map[string]*Schema {
+	return map[string]*Schema{
+		"Error": object(map[string]*Schema{
+			"error": {Type: "string"},
+		}, "error"),
+		"ListPropertyResponse": object(map[string]*Schema{
+			"listings":             arrayOf("Property"),
+			"pageNumber":           {Type: "integer"},
+			"pageSize":             {Type: "integer"},
+			"propertiestotalCount": {Type: "integer"},
+			"facets":               facets(),
+		}, "listings", "pageNumber", "pageSize", "propertiestotalCount"),
+		"ListPropertyResponseV2": object(map[string]*Schema{
+			"listings":             arrayOf("PropertyV2"),
+			"pageNumber":           {Type: "integer"},
+			"pageSize":             {Type: "integer"},
+			"propertiesTotalCount": {Type: "integer"},
+			"facets":               facets(),
+		}, "listings", "pageNumber", "pageSize", "propertiesTotalCount"),
+		"FacetCount": object(map[string]*Schema{
+			"value": {Type: "string"},
+			"count": {Type: "integer"},
+		}, "value", "count"),
+		"Property": object(propertyFields(map[string]*Schema{
+			"pricingInfos": ref("PricingInfos"),
+		})),
+		"PropertyV2": object(propertyFields(map[string]*Schema{
+			"pricingInfos":        ref("PricingInfosV2"),
+			"pricePerSquareMeter": ref("Money"),
+			"totalMonthlyCost":    ref("Money"),
+			"qualityScore":        {Type: "number", Description: "From 0 to 100, by the images, the location, the pricing and the recency"},
+			"duplicateGroupId":    {Type: "string", Description: "Shared by the probable duplicates of the source"},
+			"anomalies":           arrayOf("Anomaly"),
+		})),
+		"Address": object(map[string]*Schema{
+			"city":         {Type: "string"},
+			"neighborhood": {Type: "string"},
+			"geoLocation":  ref("GeoLocation"),
+		}),
+		"GeoLocation": object(map[string]*Schema{
+			"precision": {Type: "string"},
+			"location":  ref("Location"),
+		}),
+		"Location": object(map[string]*Schema{
+			"lon": {Type: "number"},
+			"lat": {Type: "number"},
+		}),
+		"PricingInfos": object(map[string]*Schema{
+			"yearlyIptu":       {Type: "string"},
+			"price":            {Type: "string"},
+			"businessType":     {Type: "string"},
+			"monthlyCondoFee":  {Type: "string"},
+			"period":           {Type: "string"},
+			"rentalTotalPrice": {Type: "string"},
+		}),
+		"PricingInfosV2": object(map[string]*Schema{
+			"yearlyIptu":       ref("Money"),
+			"price":            ref("Money"),
+			"businessType":     {Type: "string"},
+			"monthlyCondoFee":  ref("Money"),
+			"period":           {Type: "string"},
+			"rentalTotalPrice": ref("Money"),
+		}, "businessType"),
+		"Money": object(map[string]*Schema{
+			"amount":   {Type: "integer", Description: "Amount in cents"},
+			"currency": {Type: "string", Enum: []string{"BRL"}},
+		}, "amount", "currency"),
+		"Health": object(map[string]*Schema{
+			"status": {Type: "string"},
+		}, "status"),
+		"Readiness": object(map[string]*Schema{
+			"ready":   {Type: "boolean"},
+			"sources": {Type: "object", AdditionalProperties: ref("SourceStatus")},
+		}, "ready", "sources"),
+		"SourceStatus": object(map[string]*Schema{
+			"ready":           {Type: "boolean"},
+			"status":          {Type: "string", Enum: []string{"loading", "ready", "stale"}},
+			"snapshotVersion": {Type: "integer"},
+			"loadedAt":        {Type: "string", Format: "date-time"},
+			"ageSeconds":      {Type: "number"},
+			"properties":      {Type: "integer"},
+			"lastError":       {Type: "string"},
+			"lastErrorAt":     {Type: "string", Format: "date-time"},
+		}, "ready", "status", "properties"),
+		"LogLevel": object(map[string]*Schema{
+			"level": {Type: "string", Enum: []string{"panic", "fatal", "error", "warning", "info", "debug", "trace"}},
+		}, "level"),
+		"ConfigStatus": object(map[string]*Schema{
+			"file":         {Type: "string"},
+			"loadedAt":     {Type: "string", Format: "date-time"},
+			"reloads":      {Type: "integer"},
+			"lastReloadAt": {Type: "string", Format: "date-time"},
+			"lastErrorAt":  {Type: "string", Format: "date-time"},
+			"errors":       {Type: "array", Items: &Schema{Type: "string"}},
+		}, "loadedAt", "reloads"),
+		"Sources": object(map[string]*Schema{
+			"sources": arrayOf("Source"),
+		}, "sources"),
+		"Source": object(map[string]*Schema{
+			"name":       {Type: "string"},
+			"enabled":    {Type: "boolean"},
+			"rules":      ref("SourceRules"),
+			"quality":    ref("SourceQuality"),
+			"campaigns":  arrayOf("SourceCampaign"),
+			"properties": {Type: "integer"},
+		}, "name", "enabled", "quality", "campaigns", "properties"),
+		"Anomaly": object(map[string]*Schema{
+			"reason":   {Type: "string", Enum: []string{"price_per_square_meter_low", "price_per_square_meter_high", "condo_fee_above_rent"}},
+			"severity": {Type: "string", Enum: []string{"medium", "high"}},
+			"score":    {Type: "number", Description: "Robust z-score of the price per square meter in its neighborhood and business type"},
+		}, "reason", "severity"),
+		"Quarantine": object(map[string]*Schema{
+			"sources": {Type: "object", AdditionalProperties: arrayOf("PropertyV2")},
+		}, "sources"),
+		"SourceQuality": object(map[string]*Schema{
+			"images":     {Type: "number"},
+			"location":   {Type: "number"},
+			"pricing":    {Type: "number"},
+			"recency":    {Type: "number"},
+			"maxImages":  {Type: "integer"},
+			"staleAfter": {Type: "string", Description: "Duration like 4320h0m0s"},
+		}, "images", "location", "pricing", "recency", "maxImages", "staleAfter"),
+		"SourceRules": object(map[string]*Schema{
+			"saleMinPrice":              {Type: "integer"},
+			"rentalMinPrice":            {Type: "integer"},
+			"rentalMinSquareMeterPrice": {Type: "integer"},
+			"rentalMaxCondoFeeRatio":    {Type: "number"},
+		}, "saleMinPrice", "rentalMinPrice", "rentalMinSquareMeterPrice", "rentalMaxCondoFeeRatio"),
+		"SourceCampaign": object(map[string]*Schema{
+			"zone":            {Type: "string"},
+			"boundingBox":     ref("Zone"),
+			"businessType":    {Type: "string", Enum: []string{"SALE", "RENTAL"}},
+			"priceMultiplier": {Type: "number"},
+		}, "zone", "boundingBox", "businessType", "priceMultiplier"),
+		"Zone": object(map[string]*Schema{
+			"minLon": {Type: "number"},
+			"minLat": {Type: "number"},
+			"maxLon": {Type: "number"},
+			"maxLat": {Type: "number"},
+		}, "minLon", "minLat", "maxLon", "maxLat"),
+		"ExportRequest": object(map[string]*Schema{
+			"source":  {Type: "string"},
+			"format":  {Type: "string", Enum: []string{"ndjson", "csv", "geojson"}},
+			"columns": {Type: "array", Items: &Schema{Type: "string"}, Description: "JSON paths of the CSV columns"},
+			"filters": {Type: "object", AdditionalProperties: &Schema{Type: "string"}, Description: "Query parameters of the /properties, like businessType"},
+		}, "source"),
+		"ExportJob": object(map[string]*Schema{
+			"id":              {Type: "string"},
+			"status":          {Type: "string", Enum: []string{"queued", "running", "succeeded", "failed", "cancelled"}},
+			"source":          {Type: "string"},
+			"format":          {Type: "string", Enum: []string{"ndjson", "csv", "geojson"}},
+			"columns":         {Type: "array", Items: &Schema{Type: "string"}},
+			"filters":         {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+			"snapshotVersion": {Type: "integer"},
+			"properties":      {Type: "integer"},
+			"size":            {Type: "integer", Description: "Bytes of the artifact"},
+			"error":           {Type: "string"},
+			"createdAt":       {Type: "string", Format: "date-time"},
+			"startedAt":       {Type: "string", Format: "date-time"},
+			"finishedAt":      {Type: "string", Format: "date-time"},
+			"expiresAt":       {Type: "string", Format: "date-time"},
+			"download":        {Type: "string"},
+		}, "id", "status", "source", "format", "snapshotVersion", "properties", "size", "createdAt"),
+		"ChangesResponse": object(map[string]*Schema{
+			"source":            {Type: "string"},
+			"since":             {Type: "integer"},
+			"snapshotVersion":   {Type: "integer", Description: "Version of the last snapshot, the since of the next request"},
+			"changes":           arrayOf("Change"),
+			"pageNumber":        {Type: "integer"},
+			"pageSize":          {Type: "integer"},
+			"changesTotalCount": {Type: "integer"},
+		}, "source", "since", "snapshotVersion", "changes", "pageNumber", "pageSize", "changesTotalCount"),
+		"Change": object(map[string]*Schema{
+			"snapshotVersion": {Type: "integer"},
+			"type":            {Type: "string", Enum: []string{"added", "removed", "changed"}},
+			"id":              {Type: "string"},
+			"fields":          {Type: "object", AdditionalProperties: ref("FieldChange")},
+		}, "snapshotVersion", "type", "id"),
+		"FieldChange": object(map[string]*Schema{
+			"from": {Type: "string"},
+			"to":   {Type: "string"},
+		}, "from", "to"),
+		"PriceHistory": object(map[string]*Schema{
+			"id":     {Type: "string"},
+			"source": {Type: "string"},
+			"prices": arrayOf("PricePoint"),
+		}, "id", "source", "prices"),
+		"PricePoint": object(map[string]*Schema{
+			"observedAt":      {Type: "string", Format: "date-time"},
+			"snapshotVersion": {Type: "integer"},
+			"price":           ref("Money"),
+			"adjustedPrice":   ref("Money"),
+		}, "observedAt", "snapshotVersion", "price", "adjustedPrice"),
+		"SavedSearch": object(map[string]*Schema{
+			"id":         {Type: "string"},
+			"name":       {Type: "string"},
+			"source":     {Type: "string"},
+			"filters":    {Type: "object", AdditionalProperties: &Schema{Type: "string"}, Description: "Query parameters of the /properties, like minPrice"},
+			"radius":     ref("GeoRadius"),
+			"webhookUrl": {Type: "string", Format: "uri"},
+			"secret":     {Type: "string", Description: "Secret of the HMAC of the X-Signature, just answered on the creation"},
+			"createdAt":  {Type: "string", Format: "date-time"},
+			"updatedAt":  {Type: "string", Format: "date-time"},
+		}, "source", "webhookUrl"),
+		"GeoRadius": object(map[string]*Schema{
+			"lat":    {Type: "number", Minimum: number(-90), Maximum: number(90)},
+			"lon":    {Type: "number", Minimum: number(-180), Maximum: number(180)},
+			"meters": {Type: "number"},
+		}, "lat", "lon", "meters"),
+		"SavedSearches": object(map[string]*Schema{
+			"searches": arrayOf("SavedSearch"),
+		}, "searches"),
+		"Delivery": object(map[string]*Schema{
+			"id":              {Type: "string"},
+			"searchId":        {Type: "string"},
+			"status":          {Type: "string", Enum: []string{"pending", "delivered", "dead"}},
+			"snapshotVersion": {Type: "integer"},
+			"listings":        {Type: "array", Items: &Schema{Type: "string"}, Description: "IDs of the Properties sent"},
+			"attempts":        {Type: "integer"},
+			"createdAt":       {Type: "string", Format: "date-time"},
+			"lastAttemptAt":   {Type: "string", Format: "date-time"},
+			"nextAttemptAt":   {Type: "string", Format: "date-time"},
+			"lastStatusCode":  {Type: "integer"},
+			"lastError":       {Type: "string"},
+			"deliveredAt":     {Type: "string", Format: "date-time"},
+		}, "id", "searchId", "status", "snapshotVersion", "listings", "attempts", "createdAt"),
+		"Deliveries": object(map[string]*Schema{
+			"deliveries": arrayOf("Delivery"),
+		}, "deliveries"),
+		"Quotas": object(map[string]*Schema{
+			"day":     {Type: "string", Format: "date"},
+			"clients": arrayOf("ClientQuota"),
+		}, "day", "clients"),
+		"ClientQuota": object(map[string]*Schema{
+			"client":   {Type: "string"},
+			"requests": {Type: "integer"},
+			"quota":    {Type: "integer"},
+		}, "client", "requests", "quota"),
+		"AutocompleteResponse": object(map[string]*Schema{
+			"suggestions": arrayOf("Suggestion"),
+		}, "suggestions"),
+		"Suggestion": object(map[string]*Schema{
+			"text":       {Type: "string"},
+			"type":       {Type: "string", Enum: []string{"city", "neighborhood"}},
+			"counts":     {Type: "object", AdditionalProperties: &Schema{Type: "integer"}},
+			"totalCount": {Type: "integer"},
+		}, "text", "type", "counts", "totalCount"),
+	}
+}
+
+// propertyFields are the fields shared by every version of the Property
+func propertyFields(fields map[string]*Schema) map[string]*Schema {
+	shared := map[string]*Schema{
+		"usableAreas":   {Type: "integer"},
+		"listingType":   {Type: "string"},
+		"createdAt":     {Type: "string"},
+		"listingStatus": {Type: "string"},
+		"id":            {Type: "string"},
+		"parkingSpaces": {Type: "integer"},
+		"updatedAt":     {Type: "string"},
+		"owner":         {Type: "boolean"},
+		"images":        {Type: "array", Items: &Schema{Type: "string"}},
+		"address":       ref("Address"),
+		"bathrooms":     {Type: "integer"},
+		"bedrooms":      {Type: "integer"},
+	}
+	for name, field := range fields {
+		shared[name] = field
+	}
+	return shared
+}
+
+func facets() *Schema {
+	return &Schema{
+		Type:                 "object",
+		Description:          "Counts of the requested facets",
+		AdditionalProperties: arrayOf("FacetCount"),
+	}
+}
+
+func object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func arrayOf(name string) *Schema {
+	return &Schema{Type: "array", Items: ref(name)}
+}
+
+func jsonResponse(description, name string) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: ref(name)}},
+	}
+}
+
+func number(value float64) *float64 {
+	return &value
+}
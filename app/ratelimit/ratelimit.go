@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bucket is refilled with Rate tokens per second up to the Burst, every request takes a token
+type Bucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+// Decision of a request, with the limit closest to be exhausted
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Usage is the count of the requests of a client in the day
+type Usage struct {
+	Key      string
+	Requests int
+	Quota    int
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    float64
+	burst   int
+}
+
+type usage struct {
+	requests int
+	quota    int
+}
+
+// Limiter keeps the buckets and the daily counts of every client, it is safe for concurrent use
+type Limiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	usages  map[string]*usage
+	day     string
+	swept   time.Time
+}
+
+// New returns a Limiter without buckets
+func New() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}, usages: map[string]*usage{}}
+}
+
+// Reset forgets the buckets and the daily counts of every client
+func (l *Limiter) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.buckets, l.usages = map[string]*bucket{}, map[string]*usage{}
+}
+
+// sweepInterval is how often the full buckets are removed, a full bucket is the same as a new one
+const sweepInterval = time.Minute
+
+// Allow takes a token of every bucket and counts the request in the daily quota of the key, zero is unlimited
+// nothing is taken when any of them is exhausted, then the request is not allowed
+func (l *Limiter) Allow(now time.Time, key string, quota int, buckets ...Bucket) Decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rotate(now)
+	decision := Decision{Allowed: true, Remaining: math.MaxInt32}
+	restrict := func(limit, remaining int, reset time.Duration) {
+		if remaining < decision.Remaining {
+			decision.Limit, decision.Remaining, decision.Reset = limit, remaining, reset
+		}
+	}
+	current := make([]*bucket, len(buckets))
+	for i, b := range buckets {
+		current[i] = l.refill(b, now)
+		if current[i].tokens < 1 {
+			decision.Allowed = false
+			retryAfter := seconds((1 - current[i].tokens) / b.Rate)
+			if retryAfter > decision.RetryAfter {
+				decision.RetryAfter = retryAfter
+			}
+		}
+	}
+	counted := l.count(key, quota)
+	untilTomorrow := tomorrow(now).Sub(now)
+	if counted != nil && counted.requests >= quota {
+		decision.Allowed = false
+		decision.RetryAfter = untilTomorrow
+	}
+	if decision.Allowed {
+		for _, b := range current {
+			b.tokens--
+		}
+		if counted != nil {
+			counted.requests++
+		}
+	}
+	for i, b := range current {
+		restrict(b.burst, int(math.Max(b.tokens, 0)), seconds((float64(b.burst)-b.tokens)/buckets[i].Rate))
+	}
+	if quota > 0 {
+		restrict(quota, quota-counted.requests, untilTomorrow)
+	}
+	if decision.Remaining == math.MaxInt32 {
+		decision.Remaining = 0
+	}
+	return decision
+}
+
+// Usages returns the requests of the day of every key with a quota, in order
+func (l *Limiter) Usages(now time.Time) (string, []Usage) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rotate(now)
+	usages := []Usage{}
+	for key, counted := range l.usages {
+		usages = append(usages, Usage{Key: key, Requests: counted.requests, Quota: counted.quota})
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Key < usages[j].Key })
+	return l.day, usages
+}
+
+// count is the usage of the day of the key with a quota, the keys without one are not counted
+// and the usage of a quota removed by the reloads is dropped
+func (l *Limiter) count(key string, quota int) *usage {
+	if quota <= 0 {
+		delete(l.usages, key)
+		return nil
+	}
+	counted, ok := l.usages[key]
+	if !ok {
+		counted = &usage{}
+		l.usages[key] = counted
+	}
+	counted.quota = quota
+	return counted
+}
+
+// refill adds the tokens of the time passed since the last request, a changed limit starts a full bucket
+func (l *Limiter) refill(b Bucket, now time.Time) *bucket {
+	current, ok := l.buckets[b.Key]
+	if !ok || current.rate != b.Rate || current.burst != b.Burst {
+		current = &bucket{tokens: float64(b.Burst), updated: now, rate: b.Rate, burst: b.Burst}
+		l.buckets[b.Key] = current
+		return current
+	}
+	current.tokens = math.Min(float64(b.Burst), current.tokens+now.Sub(current.updated).Seconds()*b.Rate)
+	current.updated = now
+	return current
+}
+
+// rotate starts the counts of a new UTC day, and removes the buckets already full
+func (l *Limiter) rotate(now time.Time) {
+	if day := now.UTC().Format("2006-01-02"); day != l.day {
+		l.day = day
+		l.usages = map[string]*usage{}
+	}
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= float64(b.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func tomorrow(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"gitlab.com/zap-api/app/handler"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/openapi"
+	"gitlab.com/zap-api/config"
+)
+
+// reloadStatus keeps when the configuration was loaded and the errors of the last reload rejected
+type reloadStatus struct {
+	mutex  sync.Mutex
+	status model.ConfigStatus
+}
+
+func (s *reloadStatus) loaded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = model.ConfigStatus{LoadedAt: time.Now().UTC().Format(time.RFC3339)}
+}
+
+func (s *reloadStatus) succeed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.Reloads++
+	s.status.LoadedAt = time.Now().UTC().Format(time.RFC3339)
+	s.status.LastReloadAt = s.status.LoadedAt
+	s.status.LastErrorAt = ""
+	s.status.Errors = nil
+}
+
+func (s *reloadStatus) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.LastReloadAt = time.Now().UTC().Format(time.RFC3339)
+	s.status.LastErrorAt = s.status.LastReloadAt
+	if errs, ok := err.(config.ValidationError); ok {
+		s.status.Errors = errs
+	} else {
+		s.status.Errors = []string{err.Error()}
+	}
+}
+
+func (s *reloadStatus) get() model.ConfigStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.status
+}
+
+// currentConfig is the configuration of the requests, it is replaced by the reloads
+func (a *App) currentConfig() *config.Config {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.Config
+}
+
+// currentDocument is the OpenAPI document of the current configuration, the sources accepted may change on the reloads
+func (a *App) currentDocument() *openapi.Document {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.Document
+}
+
+// Reload loads the configuration again from the file, the environment and the arguments of the start
+// an invalid configuration is rejected and the current one is kept, a valid one replaces it at once
+// and its rules, zones and campaigns are applied to the last feed, without downloading it again
+// the server, cache, tracing, versions and watch settings are read on the start, they are applied on the restart
+// and the reloads run one at a time, so the one of the SIGHUP and the one of the watch are not mixed
+func (a *App) Reload(ctx context.Context) error {
+	a.reloading.Lock()
+	defer a.reloading.Unlock()
+	current := a.currentConfig()
+	logger := current.Logger.WithField("file", current.File)
+	next, err := config.Load(current.Args)
+	if err != nil {
+		logger.WithError(err).Error("Configuration rejected, keeping the current one")
+		a.reloads.fail(err)
+		return err
+	}
+	next.Cache = current.Cache
+	next.Logger = current.Logger
+	if !reflect.DeepEqual(next.Server, current.Server) || !reflect.DeepEqual(next.CacheTTL, current.CacheTTL) ||
+		!reflect.DeepEqual(next.Tracing, current.Tracing) || !reflect.DeepEqual(next.Versions, current.Versions) ||
+		!reflect.DeepEqual(next.Reload, current.Reload) {
+		logger.Warn("The server, cache, tracing, versions and reload settings changed, they are applied on the restart")
+	}
+	a.mutex.Lock()
+	a.Config = next
+	// The deprecations are the ones of the routes, set on the start
+	a.Document = openapi.NewDocument(next.SortedDatasources(), next.Auth.KeysFile != "", *current.Versions)
+	a.mutex.Unlock()
+	a.setLogger(current.Logging)
+	a.reloads.succeed()
+	logger.Info("Configuration reloaded")
+	handler.Reevaluate(ctx, next)
+	return nil
+}
+
+// watchConfig reloads the configuration when the modification time or the size of its file changes
+// the file is polled, the changes are rare and it also works for the files mounted by the orchestrator
+func (a *App) watchConfig(ctx context.Context) {
+	current := a.currentConfig()
+	if current.File == "" || current.Reload.WatchInterval == 0 {
+		return
+	}
+	logger := current.Logger.WithField("file", current.File)
+	last, _ := os.Stat(current.File)
+	ticker := time.NewTicker(current.Reload.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(current.File)
+			if err != nil {
+				if last != nil {
+					logger.WithError(err).Error("Configuration file not found, keeping the current configuration")
+				}
+				last = nil
+				continue
+			}
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				logger.Info("Configuration file changed")
+				a.Reload(ctx)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gitlab.com/zap-api/config"
+)
+
+// RouteGroup has the routes of a version of the API, sharing its chain of middlewares
+type RouteGroup struct {
+	Router *mux.Router
+}
+
+// Version creates the group of routes under the prefix of the version, like /v1
+// the deprecated versions answer every request with the Deprecation and Sunset headers
+func (a *App) Version(name string, middlewares ...mux.MiddlewareFunc) *RouteGroup {
+	return a.group(a.Router.PathPrefix("/"+name).Subrouter(), name, middlewares...)
+}
+
+// Legacy creates the group of routes without prefix, they are kept for the clients older than the versions
+func (a *App) Legacy(name string, middlewares ...mux.MiddlewareFunc) *RouteGroup {
+	return a.group(a.Router.NewRoute().Subrouter(), name, middlewares...)
+}
+
+// Admin creates the group of the administration routes, under /admin
+func (a *App) Admin(middlewares ...mux.MiddlewareFunc) *RouteGroup {
+	router := a.Router.PathPrefix("/admin").Subrouter()
+	router.Use(middlewares...)
+	return &RouteGroup{Router: router}
+}
+
+func (a *App) group(router *mux.Router, name string, middlewares ...mux.MiddlewareFunc) *RouteGroup {
+	if version, ok := (*a.Config.Versions)[name]; ok && version.Deprecated {
+		router.Use(deprecation(version))
+	}
+	router.Use(middlewares...)
+	return &RouteGroup{Router: router}
+}
+
+// deprecation sets the headers telling the clients the version will be removed
+func deprecation(version *config.Version) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !version.Sunset.IsZero() {
+				w.Header().Set("Sunset", version.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if version.Successor != "" {
+				w.Header().Set("Link", "</"+version.Successor+">; rel=\"successor-version\"")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// route of the versions, the handler of the v2 is the one of the v1 when it has none
+type route struct {
+	method  string
+	path    string
+	handler func(w http.ResponseWriter, r *http.Request)
+	v2      func(w http.ResponseWriter, r *http.Request)
+}
+
+// handle adds the routes to the group of the version, with the handlers of the v2 on the v2
+func (g *RouteGroup) handle(routes []route, version string) {
+	for _, route := range routes {
+		handler := route.handler
+		if version == "v2" && route.v2 != nil {
+			handler = route.v2
+		}
+		g.Router.HandleFunc(route.path, handler).Methods(route.method)
+	}
+}
+
+// Wrap the router for GET method
+func (g *RouteGroup) Get(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	g.Router.HandleFunc(path, f).Methods("GET")
+}
+
+// Wrap the router for POST method
+func (g *RouteGroup) Post(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	g.Router.HandleFunc(path, f).Methods("POST")
+}
+
+// Wrap the router for PUT method
+func (g *RouteGroup) Put(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	g.Router.HandleFunc(path, f).Methods("PUT")
+}
+
+// Wrap the router for PATCH method
+func (g *RouteGroup) Patch(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	g.Router.HandleFunc(path, f).Methods("PATCH")
+}
+
+// Wrap the router for DELETE method
+func (g *RouteGroup) Delete(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	g.Router.HandleFunc(path, f).Methods("DELETE")
+}
+
+// apiVersion tells the client which version answered the request
+func apiVersion(name string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("API-Version", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewStdoutExporter writes one JSON document for every Span, it is used by the local runs
+func NewStdoutExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
+
+// NewOTLPExporter posts the Spans to the endpoint of the collector, like http://localhost:4318/v1/traces
+func NewOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+}
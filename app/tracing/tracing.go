@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name of the Tracer of the API
+const instrumentation = "gitlab.com/zap-api"
+
+// tracingErrors counts the failures of the SDK, like the batches of Spans not sent to the collector
+var tracingErrors = promauto.NewCounter(prometheus.CounterOpts{Name: "zap_tracing_errors_total",
+	Help: "Errors of the tracing, like the Spans not sent to the collector."})
+
+var (
+	providerMutex sync.Mutex
+	provider      *sdktrace.TracerProvider
+)
+
+func init() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// SetLogger logs the errors of the SDK, they are also counted in the metrics
+func SetLogger(logger logrus.FieldLogger) {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		tracingErrors.Inc()
+		logger.WithError(err).Error("Tracing failed")
+	}))
+}
+
+// SetExporter sends the finished Spans of the service to the exporter, in batches when batched, nil drops them
+// the Spans of the previous exporter still waiting are sent before it is shut down
+func SetExporter(exporter sdktrace.SpanExporter, serviceName string, batched bool) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	}
+	if exporter != nil && batched {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	} else if exporter != nil {
+		options = append(options, sdktrace.WithSyncer(exporter))
+	}
+	next := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(next)
+	providerMutex.Lock()
+	previous := provider
+	provider = next
+	providerMutex.Unlock()
+	if previous != nil {
+		if err := previous.Shutdown(context.Background()); err != nil {
+			otel.Handle(err)
+		}
+	}
+}
+
+// Shutdown sends the Spans still waiting in the batches, until the context is done
+func Shutdown(ctx context.Context) error {
+	providerMutex.Lock()
+	current := provider
+	providerMutex.Unlock()
+	if current == nil {
+		return nil
+	}
+	return current.Shutdown(ctx)
+}
+
+// Start creates a Span, child of the Span in the context when there is one
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, options...)
+}
+
+// SetError marks the Span as failed by the error
+func SetError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Extract returns the context with the remote parent from the traceparent header, when it is valid
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject sets the traceparent header of an outgoing request with the current Span
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
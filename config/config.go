@@ -1,36 +1,655 @@
 package config
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 )
 
 // Config will setup the Endpoints, the sources that will be requested, Log and Memory Cache configuration
+// it is layered: the defaults, then the TOML file, then the environment variables and then the flags
 type Config struct {
-	Endpoints   *Endpoint
-	Datasources *map[string]bool
-	Cache       *cache.Cache
-	Logger      *logrus.Logger
+	Endpoints   *Endpoint               `toml:"endpoints"`
+	Datasources *map[string]bool        `toml:"datasources"`
+	Rules       *map[string]*Rules      `toml:"rules"`
+	Zones       *map[string]*Zone       `toml:"zones"`
+	Campaigns   *map[string][]*Campaign `toml:"campaigns"`
+	Quality     *map[string]*Quality    `toml:"quality"`
+	Versions    *map[string]*Version    `toml:"versions"`
+	CacheTTL    *CacheTTL               `toml:"cache"`
+	Logging     *Logging                `toml:"logging"`
+	Tracing     *Tracing                `toml:"tracing"`
+	Ingestion   *Ingestion              `toml:"ingestion"`
+	Server      *Server                 `toml:"server"`
+	Reload      *Reload                 `toml:"reload"`
+	Auth        *Auth                   `toml:"auth"`
+	RateLimit   *RateLimit              `toml:"rate_limit"`
+	CORS        *CORS                   `toml:"cors"`
+	Export      *Export                 `toml:"export"`
+	Changes     *Changes                `toml:"changes"`
+	Stream      *Stream                 `toml:"stream"`
+	Searches    *Searches               `toml:"searches"`
+	Prices      *Prices                 `toml:"prices"`
+	Dedup       *Dedup                  `toml:"dedup"`
+	Anomalies   *Anomalies              `toml:"anomalies"`
+	Cache       *cache.Cache            `toml:"-"`
+	Logger      *logrus.Logger          `toml:"-"`
+	PrintConfig bool                    `toml:"-"`
+	// Clients are loaded from the keys file of the Auth, they are kept out of the configuration printed
+	Clients map[string]*Client `toml:"-"`
+	// File and Args are the layers of the start, the configuration is loaded again from them on the reloads
+	File string   `toml:"-"`
+	Args []string `toml:"-"`
 }
 
 // Endpoints for the future Requests
 type Endpoint struct {
-	ZapProperties string
+	ZapProperties string `toml:"zap_properties"`
 }
 
-func GetConfig() *Config {
+// Rules a Property must follow to be accepted by a source, the zero values disable the optional ones
+type Rules struct {
+	SaleMinPrice              int     `toml:"sale_min_price"`
+	RentalMinPrice            int     `toml:"rental_min_price"`
+	RentalMinSquareMeterPrice int     `toml:"rental_min_square_meter_price"`
+	RentalMaxCondoFeeRatio    float64 `toml:"rental_max_condo_fee_ratio"`
+}
+
+// Zone is a bounding box where the campaigns of the sources are applied
+type Zone struct {
+	MinLon float64 `toml:"min_lon"`
+	MinLat float64 `toml:"min_lat"`
+	MaxLon float64 `toml:"max_lon"`
+	MaxLat float64 `toml:"max_lat"`
+}
+
+// Campaign multiplies the price of the Properties of a business type inside the zone
+type Campaign struct {
+	Zone            string  `toml:"zone"`
+	BusinessType    string  `toml:"business_type"`
+	PriceMultiplier float64 `toml:"price_multiplier"`
+}
+
+// CacheTTL of the snapshots in the memory cache
+type CacheTTL struct {
+	DefaultExpiration time.Duration `toml:"default_expiration"`
+	CleanupInterval   time.Duration `toml:"cleanup_interval"`
+}
+
+// Logging has the format, json or text, and the initial level of the Logger
+type Logging struct {
+	Format string `toml:"format"`
+	Level  string `toml:"level"`
+}
+
+// Tracing sends the Spans to the Exporter "otlp" or "stdout", they are dropped when it is empty
+type Tracing struct {
+	Exporter    string `toml:"exporter"`
+	Endpoint    string `toml:"endpoint"`
+	ServiceName string `toml:"service_name"`
+}
+
+// Ingestion refreshes the snapshots on every Interval, the API is not ready when they are older than MaxAge
+type Ingestion struct {
+	Interval time.Duration `toml:"interval"`
+	MaxAge   time.Duration `toml:"max_age"`
+}
+
+// Server has the timeouts of the HTTP server, the WriteTimeout is above the 100s of the first request without cache
+// the Compression gzips the responses of the clients accepting it
+type Server struct {
+	Host              string        `toml:"host"`
+	ReadTimeout       time.Duration `toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `toml:"write_timeout"`
+	IdleTimeout       time.Duration `toml:"idle_timeout"`
+	MaxHeaderBytes    int           `toml:"max_header_bytes"`
+	ShutdownTimeout   time.Duration `toml:"shutdown_timeout"`
+	Compression       bool          `toml:"compression"`
+}
+
+// Reload checks the configuration file for changes on every WatchInterval, zero disables the watch but not the SIGHUP
+type Reload struct {
+	WatchInterval time.Duration `toml:"watch_interval"`
+}
+
+// Auth requires an API key on the requests of the Properties and of the administration when the KeysFile is set
+type Auth struct {
+	KeysFile string `toml:"keys_file"`
+}
+
+// Client of the API, its key is stored as the hex SHA-256 hash and it may read just its Sources
+// the first source is the one of the requests without the source header, the Admin may call the administration routes
+type Client struct {
+	KeyHash string   `toml:"key_hash"`
+	Sources []string `toml:"sources"`
+	Admin   bool     `toml:"admin"`
+}
+
+// keysFile is the TOML file of the clients, one table for every client like [clients.crm]
+type keysFile struct {
+	Clients map[string]*Client `toml:"clients"`
+}
+
+// RateLimit of the clients, by their API keys or by their IPs when the keys are not required
+// the Default is the limit of every client without its own, the Routes are limited for every client too
+// the routes are named without the version, like "/properties", and MaxPageSize caps the limit of the pages
+type RateLimit struct {
+	Default           *Limit            `toml:"default"`
+	Clients           map[string]*Limit `toml:"clients"`
+	Routes            map[string]*Limit `toml:"routes"`
+	TrustForwardedFor bool              `toml:"trust_forwarded_for"`
+	MaxPageSize       int               `toml:"max_page_size"`
+}
+
+// Limit is a token bucket refilled with RequestsPerSecond up to the Burst, the zero RequestsPerSecond disables it
+// the DailyQuota counts the requests of the UTC day, zero is unlimited
+type Limit struct {
+	RequestsPerSecond float64 `toml:"requests_per_second"`
+	Burst             int     `toml:"burst"`
+	DailyQuota        int     `toml:"daily_quota"`
+}
+
+// CORS lets the front-ends of the portals call the API from the browsers, the Origins are allowed by source
+// and the origin "*" allows every origin, MaxAge is how long the browsers keep the preflight
+type CORS struct {
+	Origins map[string][]string `toml:"origins"`
+	MaxAge  time.Duration       `toml:"max_age"`
+}
+
+// Export of the Properties of a source, the Columns are the default fields of the CSV named by their JSON paths
+// the jobs write their artifacts in the Directory, they are removed after the Retention, and MaxConcurrent jobs
+// run at the same time while up to MaxQueued others wait in the queue
+type Export struct {
+	Columns       []string      `toml:"columns"`
+	Directory     string        `toml:"directory"`
+	Retention     time.Duration `toml:"retention"`
+	MaxConcurrent int           `toml:"max_concurrent"`
+	MaxQueued     int           `toml:"max_queued"`
+}
+
+// Changes between the snapshots of the sources are kept for the Retention, the older ones are no longer answered
+type Changes struct {
+	Retention time.Duration `toml:"retention"`
+}
+
+// Stream of the changes, the Heartbeat keeps the idle connections open through the proxies
+type Stream struct {
+	Heartbeat time.Duration `toml:"heartbeat"`
+}
+
+// Searches saved by the clients are kept in the File, their webhooks are retried MaxAttempts times
+// waiting the Backoff doubled on every attempt, the History is how many deliveries finished are kept
+// the webhooks to the loopback, link-local and private addresses are refused unless AllowPrivateWebhooks
+type Searches struct {
+	File                 string        `toml:"file"`
+	MaxAttempts          int           `toml:"max_attempts"`
+	Backoff              time.Duration `toml:"backoff"`
+	Timeout              time.Duration `toml:"timeout"`
+	History              int           `toml:"history"`
+	AllowPrivateWebhooks bool          `toml:"allow_private_webhooks"`
+}
+
+// Prices observed for every Property are kept in the File, the Properties not seen for the Retention are forgotten
+type Prices struct {
+	File      string        `toml:"file"`
+	Retention time.Duration `toml:"retention"`
+}
+
+// Dedup groups the probable duplicates of a source, they have the same business type, bedrooms and bathrooms,
+// the usable areas and the prices inside the tolerances, like 0.05 for 5%, and they are within the Distance in meters
+// or share MinSharedImages image URLs, zero disables the images
+type Dedup struct {
+	Distance        float64 `toml:"distance"`
+	AreaTolerance   float64 `toml:"area_tolerance"`
+	PriceTolerance  float64 `toml:"price_tolerance"`
+	MinSharedImages int     `toml:"min_shared_images"`
+}
+
+// Anomalies of the prices are found on the ingestion, the price per square meter is an outlier of its neighborhood and
+// business type when its robust z-score, by the median and the MAD, is at least the Threshold, and high from the HighThreshold
+// the groups smaller than MinGroupSize are not evaluated, and the Properties with an anomaly of the Quarantine severity,
+// medium or high, are kept out of the snapshots, empty disables it
+type Anomalies struct {
+	Threshold     float64 `toml:"threshold"`
+	HighThreshold float64 `toml:"high_threshold"`
+	MinGroupSize  int     `toml:"min_group_size"`
+	Quarantine    string  `toml:"quarantine"`
+}
+
+// Quality weights the parts of the score of the Properties of a source, the score is their weighted average from 0 to 100
+// the images count up to MaxImages, and the UpdatedAt is no longer recent after StaleAfter
+type Quality struct {
+	Images     float64       `toml:"images"`
+	Location   float64       `toml:"location"`
+	Pricing    float64       `toml:"pricing"`
+	Recency    float64       `toml:"recency"`
+	MaxImages  int           `toml:"max_images"`
+	StaleAfter time.Duration `toml:"stale_after"`
+}
+
+// DefaultQuality scores the Properties of the sources without their own weights
+func DefaultQuality() *Quality {
+	return &Quality{Images: 0.3, Location: 0.25, Pricing: 0.25, Recency: 0.2, MaxImages: 10, StaleAfter: 180 * 24 * time.Hour}
+}
+
+// QualityOf returns the weights of the source, or the default ones
+func (c *Config) QualityOf(source string) *Quality {
+	if quality, ok := (*c.Quality)[source]; ok {
+		return quality
+	}
+	return DefaultQuality()
+}
+
+// Version of the API, the deprecated ones tell the clients their Sunset and the successor version
+type Version struct {
+	Deprecated bool      `toml:"deprecated"`
+	Sunset     time.Time `toml:"sunset,omitempty"`
+	Successor  string    `toml:"successor"`
+}
+
+// Default is the configuration before any layer, with the rules and zones of the portals
+func Default() *Config {
 	return &Config{
-		Endpoints: &Endpoint{
-			ZapProperties: os.Getenv("ZAP_PROPERTIES_ENDPOINT"),
-		},
+		Endpoints: &Endpoint{},
 		Datasources: &map[string]bool{
 			"zap":      true,
 			"vivareal": true,
 		},
-		Cache:  cache.New(10*time.Minute, 60*time.Minute),
-		Logger: logrus.New(),
+		Rules: &map[string]*Rules{
+			"zap":      {SaleMinPrice: 600000, RentalMinPrice: 3500, RentalMinSquareMeterPrice: 3500},
+			"vivareal": {SaleMinPrice: 700000, RentalMinPrice: 4000, RentalMaxCondoFeeRatio: 0.3},
+		},
+		Zones: &map[string]*Zone{
+			"grupozap": {MinLon: -46.693419, MinLat: -23.568704, MaxLon: -46.641146, MaxLat: -23.546686},
+		},
+		Campaigns: &map[string][]*Campaign{
+			"zap":      {{Zone: "grupozap", BusinessType: "SALE", PriceMultiplier: 0.9}},
+			"vivareal": {{Zone: "grupozap", BusinessType: "RENTAL", PriceMultiplier: 1.5}},
+		},
+		Quality: &map[string]*Quality{
+			"zap":      DefaultQuality(),
+			"vivareal": DefaultQuality(),
+		},
+		Versions: &map[string]*Version{
+			"v1": {Successor: "v2"},
+			"v2": {},
+		},
+		CacheTTL: &CacheTTL{
+			DefaultExpiration: 10 * time.Minute,
+			CleanupInterval:   60 * time.Minute,
+		},
+		Logging: &Logging{Format: "json", Level: "info"},
+		Tracing: &Tracing{
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "zap-api",
+		},
+		Ingestion: &Ingestion{
+			Interval: 5 * time.Minute,
+			MaxAge:   30 * time.Minute,
+		},
+		Server: &Server{
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      120 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+			Compression:       true,
+		},
+		Reload: &Reload{WatchInterval: 5 * time.Second},
+		Auth:   &Auth{},
+		RateLimit: &RateLimit{
+			Default:     &Limit{},
+			Clients:     map[string]*Limit{},
+			Routes:      map[string]*Limit{},
+			MaxPageSize: 100,
+		},
+		CORS: &CORS{
+			Origins: map[string][]string{},
+			MaxAge:  10 * time.Minute,
+		},
+		Export: &Export{
+			Columns: []string{
+				"id", "listingType", "listingStatus", "usableAreas", "bedrooms", "bathrooms", "parkingSpaces",
+				"address.city", "address.neighborhood", "address.geoLocation.location.lat", "address.geoLocation.location.lon",
+				"pricingInfos.businessType", "pricingInfos.price", "pricingInfos.monthlyCondoFee", "updatedAt",
+			},
+			Directory:     filepath.Join("data", "exports"),
+			Retention:     24 * time.Hour,
+			MaxConcurrent: 2,
+			MaxQueued:     100,
+		},
+		Changes: &Changes{Retention: 24 * time.Hour},
+		Stream:  &Stream{Heartbeat: 15 * time.Second},
+		Searches: &Searches{
+			File:        filepath.Join("data", "searches.json"),
+			MaxAttempts: 5,
+			Backoff:     10 * time.Second,
+			Timeout:     10 * time.Second,
+			History:     1000,
+		},
+		Prices: &Prices{
+			File:      filepath.Join("data", "prices.json"),
+			Retention: 90 * 24 * time.Hour,
+		},
+		Dedup:     &Dedup{Distance: 50, AreaTolerance: 0.05, PriceTolerance: 0.05, MinSharedImages: 2},
+		Anomalies: &Anomalies{Threshold: 3.5, HighThreshold: 10, MinGroupSize: 5},
+		Logger:    logrus.New(),
+	}
+}
+
+// GetConfig returns the defaults with the configuration file and the environment variables, without flags
+// the errors are not returned, they are found again by the Validate of the App initialization
+func GetConfig() *Config {
+	config, _ := Load(nil)
+	return config
+}
+
+// Load layers the configuration and returns every error found, the Config is returned even when it is invalid
+func Load(args []string) (*Config, error) {
+	config := Default()
+	errs := []string{}
+	flags := flag.NewFlagSet("zap-api", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "TOML configuration `file`, also read from CONFIG_FILE")
+	printConfig := flags.Bool("print-config", false, "print the configuration with every layer applied and exit")
+	for _, s := range settings {
+		flags.String(s.flag, "", s.usage+", also read from "+s.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if *file != "" {
+		errs = append(errs, config.loadFile(*file)...)
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(config, value); err != nil {
+				errs = append(errs, s.env+": "+err.Error())
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(config, f.Value.String()); err != nil {
+					errs = append(errs, "--"+s.flag+": "+err.Error())
+				}
+			}
+		}
+	})
+	if config.Auth.KeysFile != "" {
+		errs = append(errs, config.loadKeys(config.Auth.KeysFile)...)
+	}
+	config.PrintConfig = *printConfig
+	config.File = *file
+	config.Args = args
+	config.Cache = cache.New(config.CacheTTL.DefaultExpiration, config.CacheTTL.CleanupInterval)
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err.(ValidationError)...)
+	}
+	if len(errs) > 0 {
+		return config, ValidationError(errs)
+	}
+	return config, nil
+}
+
+// loadFile layers the TOML file over the configuration
+func (c *Config) loadFile(path string) []string {
+	return decodeFile(path, c)
+}
+
+// loadKeys reads the clients from the keys file, it is read again on the reloads so the keys can be rotated
+func (c *Config) loadKeys(path string) []string {
+	keys := keysFile{}
+	errs := decodeFile(path, &keys)
+	c.Clients = keys.Clients
+	return errs
+}
+
+// TOML formats the configuration like the configuration file
+func (c *Config) TOML() string {
+	out := &strings.Builder{}
+	if err := toml.NewEncoder(out).Encode(c); err != nil {
+		return "# " + err.Error() + "\n"
+	}
+	return out.String()
+}
+
+// setting is a value of the configuration that can be set by an environment variable and a flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"ZAP_PROPERTIES_ENDPOINT", "zap-properties-endpoint", "URL of the Properties", func(c *Config, value string) error {
+		c.Endpoints.ZapProperties = value
+		return nil
+	}},
+	{"HOST", "host", "address the API listens to, like :8080", func(c *Config, value string) error {
+		c.Server.Host = value
+		return nil
+	}},
+	{"DATASOURCES", "datasources", "comma separated sources enabled", func(c *Config, value string) error {
+		datasources := map[string]bool{}
+		for _, source := range strings.Split(value, ",") {
+			if source = strings.TrimSpace(source); source != "" {
+				datasources[source] = true
+			}
+		}
+		c.Datasources = &datasources
+		return nil
+	}},
+	{"V1_SUNSET", "v1-sunset", "RFC3339 date the v1 will be removed, deprecating it", func(c *Config, value string) error {
+		version, ok := (*c.Versions)["v1"]
+		if !ok {
+			return fmt.Errorf("version v1 is not configured")
+		}
+		version.Deprecated = true
+		return parseTime(value, &version.Sunset)
+	}},
+	{"CACHE_DEFAULT_EXPIRATION", "cache-default-expiration", "expiration of the snapshots in the cache", func(c *Config, value string) error {
+		return parseDuration(value, &c.CacheTTL.DefaultExpiration)
+	}},
+	{"CACHE_CLEANUP_INTERVAL", "cache-cleanup-interval", "interval of the cleanup of the cache", func(c *Config, value string) error {
+		return parseDuration(value, &c.CacheTTL.CleanupInterval)
+	}},
+	{"LOG_FORMAT", "log-format", "json or text", func(c *Config, value string) error {
+		c.Logging.Format = value
+		return nil
+	}},
+	{"LOG_LEVEL", "log-level", "initial level of the logs", func(c *Config, value string) error {
+		c.Logging.Level = value
+		return nil
+	}},
+	{"TRACING_EXPORTER", "tracing-exporter", "otlp or stdout, the traces are dropped when it is empty", func(c *Config, value string) error {
+		c.Tracing.Exporter = value
+		return nil
+	}},
+	{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "tracing-endpoint", "URL of the OTLP collector", func(c *Config, value string) error {
+		c.Tracing.Endpoint = value
+		return nil
+	}},
+	{"OTEL_SERVICE_NAME", "tracing-service-name", "service name of the traces", func(c *Config, value string) error {
+		c.Tracing.ServiceName = value
+		return nil
+	}},
+	{"INGESTION_INTERVAL", "ingestion-interval", "interval of the ingestion", func(c *Config, value string) error {
+		return parseDuration(value, &c.Ingestion.Interval)
+	}},
+	{"DATASET_MAX_AGE", "dataset-max-age", "age of the snapshots making the API not ready", func(c *Config, value string) error {
+		return parseDuration(value, &c.Ingestion.MaxAge)
+	}},
+	{"SERVER_READ_TIMEOUT", "server-read-timeout", "read timeout of the server", func(c *Config, value string) error {
+		return parseDuration(value, &c.Server.ReadTimeout)
+	}},
+	{"SERVER_READ_HEADER_TIMEOUT", "server-read-header-timeout", "read header timeout of the server", func(c *Config, value string) error {
+		return parseDuration(value, &c.Server.ReadHeaderTimeout)
+	}},
+	{"SERVER_WRITE_TIMEOUT", "server-write-timeout", "write timeout of the server", func(c *Config, value string) error {
+		return parseDuration(value, &c.Server.WriteTimeout)
+	}},
+	{"SERVER_IDLE_TIMEOUT", "server-idle-timeout", "idle timeout of the server", func(c *Config, value string) error {
+		return parseDuration(value, &c.Server.IdleTimeout)
+	}},
+	{"SERVER_MAX_HEADER_BYTES", "server-max-header-bytes", "maximum size of the headers", func(c *Config, value string) error {
+		return parseInt(value, &c.Server.MaxHeaderBytes)
+	}},
+	{"SERVER_SHUTDOWN_TIMEOUT", "server-shutdown-timeout", "time to drain the requests on shutdown", func(c *Config, value string) error {
+		return parseDuration(value, &c.Server.ShutdownTimeout)
+	}},
+	{"AUTH_KEYS_FILE", "auth-keys-file", "TOML file of the clients and the hashes of their API keys", func(c *Config, value string) error {
+		c.Auth.KeysFile = value
+		return nil
+	}},
+	{"RATE_LIMIT_REQUESTS_PER_SECOND", "rate-limit-requests-per-second", "requests per second of every client, 0 disables it", func(c *Config, value string) error {
+		requestsPerSecond, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, found %s", value)
+		}
+		c.RateLimit.Default.RequestsPerSecond = requestsPerSecond
+		return nil
+	}},
+	{"RATE_LIMIT_BURST", "rate-limit-burst", "requests of every client above the rate", func(c *Config, value string) error {
+		return parseInt(value, &c.RateLimit.Default.Burst)
+	}},
+	{"RATE_LIMIT_DAILY_QUOTA", "rate-limit-daily-quota", "requests of every client in the day, 0 is unlimited", func(c *Config, value string) error {
+		return parseInt(value, &c.RateLimit.Default.DailyQuota)
+	}},
+	{"MAX_PAGE_SIZE", "max-page-size", "maximum limit of the pages", func(c *Config, value string) error {
+		return parseInt(value, &c.RateLimit.MaxPageSize)
+	}},
+	{"EXPORT_COLUMNS", "export-columns", "default columns of the CSV exports, separated by commas", func(c *Config, value string) error {
+		c.Export.Columns = strings.Split(value, ",")
+		return nil
+	}},
+	{"EXPORT_DIRECTORY", "export-directory", "directory of the artifacts of the export jobs", func(c *Config, value string) error {
+		c.Export.Directory = value
+		return nil
+	}},
+	{"EXPORT_RETENTION", "export-retention", "how long the finished export jobs and their artifacts are kept", func(c *Config, value string) error {
+		return parseDuration(value, &c.Export.Retention)
+	}},
+	{"EXPORT_MAX_CONCURRENT", "export-max-concurrent", "maximum of export jobs running at the same time", func(c *Config, value string) error {
+		return parseInt(value, &c.Export.MaxConcurrent)
+	}},
+	{"EXPORT_MAX_QUEUED", "export-max-queued", "maximum of export jobs waiting in the queue", func(c *Config, value string) error {
+		return parseInt(value, &c.Export.MaxQueued)
+	}},
+	{"CHANGES_RETENTION", "changes-retention", "how long the changes between the snapshots are kept", func(c *Config, value string) error {
+		return parseDuration(value, &c.Changes.Retention)
+	}},
+	{"STREAM_HEARTBEAT", "stream-heartbeat", "interval of the comments sent to the idle streams", func(c *Config, value string) error {
+		return parseDuration(value, &c.Stream.Heartbeat)
+	}},
+	{"SEARCHES_FILE", "searches-file", "file of the saved searches and of their deliveries", func(c *Config, value string) error {
+		c.Searches.File = value
+		return nil
+	}},
+	{"SEARCHES_MAX_ATTEMPTS", "searches-max-attempts", "attempts of a webhook before it is dead", func(c *Config, value string) error {
+		return parseInt(value, &c.Searches.MaxAttempts)
+	}},
+	{"SEARCHES_BACKOFF", "searches-backoff", "wait before the first retry of a webhook, doubled on every attempt", func(c *Config, value string) error {
+		return parseDuration(value, &c.Searches.Backoff)
+	}},
+	{"SEARCHES_ALLOW_PRIVATE_WEBHOOKS", "searches-allow-private-webhooks", "accept the webhooks to the loopback, link-local and private addresses", func(c *Config, value string) error {
+		return parseBool(value, &c.Searches.AllowPrivateWebhooks)
+	}},
+	{"PRICES_FILE", "prices-file", "file of the price history of the Properties", func(c *Config, value string) error {
+		c.Prices.File = value
+		return nil
+	}},
+	{"PRICES_RETENTION", "prices-retention", "how long the price history of a Property not seen is kept", func(c *Config, value string) error {
+		return parseDuration(value, &c.Prices.Retention)
+	}},
+	{"DEDUP_DISTANCE", "dedup-distance", "meters between the probable duplicates", func(c *Config, value string) error {
+		return parseFloat(value, &c.Dedup.Distance)
+	}},
+	{"DEDUP_PRICE_TOLERANCE", "dedup-price-tolerance", "ratio of the difference of the prices of the probable duplicates", func(c *Config, value string) error {
+		return parseFloat(value, &c.Dedup.PriceTolerance)
+	}},
+	{"ANOMALIES_THRESHOLD", "anomalies-threshold", "robust z-score of the price per square meter of the outliers", func(c *Config, value string) error {
+		return parseFloat(value, &c.Anomalies.Threshold)
+	}},
+	{"ANOMALIES_QUARANTINE", "anomalies-quarantine", "severity of the anomalies kept out of the snapshots, medium or high, empty disables it", func(c *Config, value string) error {
+		c.Anomalies.Quarantine = value
+		return nil
+	}},
+	{"CONFIG_WATCH_INTERVAL", "config-watch-interval", "interval of the checks for changes in the configuration file, 0 disables it", func(c *Config, value string) error {
+		return parseDuration(value, &c.Reload.WatchInterval)
+	}},
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like \"5m\", found %s", value)
+	}
+	*target = duration
+	return nil
+}
+
+func parseInt(value string, target *int) error {
+	integer, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected an integer, found %s", value)
+	}
+	*target = integer
+	return nil
+}
+
+func parseFloat(value string, target *float64) error {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("expected a number, found %s", value)
+	}
+	*target = number
+	return nil
+}
+
+func parseBool(value string, target *bool) error {
+	boolean, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true or false, found %s", value)
+	}
+	*target = boolean
+	return nil
+}
+
+func parseTime(value string, target *time.Time) error {
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("expected a RFC3339 date, found %s", value)
+	}
+	*target = date
+	return nil
+}
+
+// Enabled tells if the source is configured and enabled
+func (c *Config) Enabled(source string) bool {
+	return (*c.Datasources)[source]
+}
+
+// SortedDatasources returns the names of the sources enabled, in order
+func (c *Config) SortedDatasources() []string {
+	sources := []string{}
+	for source, enabled := range *c.Datasources {
+		if enabled {
+			sources = append(sources, source)
+		}
 	}
+	sort.Strings(sources)
+	return sources
 }
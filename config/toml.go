@@ -0,0 +1,125 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// decodeFile layers the TOML file over the target, the tables only override the keys they have
+// so the file is layered over the defaults, also in the tables of the maps like [rules.zap]
+func decodeFile(path string, target interface{}) []string {
+	table := map[string]toml.Primitive{}
+	metadata, err := toml.DecodeFile(path, &table)
+	if err != nil {
+		return []string{path + ": " + err.Error()}
+	}
+	decoder := &fileDecoder{metadata: &metadata, unknown: map[string]bool{}}
+	decoder.layer(table, reflect.ValueOf(target).Elem(), "")
+	// The keys of the values decoded at once, like the arrays of tables, are left undecoded without a field
+	for _, key := range metadata.Undecoded() {
+		if !decoder.inUnknown(key) {
+			decoder.errs = append(decoder.errs, key.String()+": unknown key")
+		}
+	}
+	for i := range decoder.errs {
+		decoder.errs[i] = path + ": " + decoder.errs[i]
+	}
+	return decoder.errs
+}
+
+// fileDecoder keeps the errors of a file, and the keys already reported as unknown with every key inside them
+type fileDecoder struct {
+	metadata *toml.MetaData
+	errs     []string
+	unknown  map[string]bool
+}
+
+// layer decodes the keys of the table into the fields of the struct, or into the elements of the map
+// keeping the elements already in the map, the other values are decoded by the TOML package
+func (d *fileDecoder) layer(table map[string]toml.Primitive, target reflect.Value, path string) {
+	fields := map[string]int{}
+	if target.Kind() == reflect.Struct {
+		fields = tomlFields(target.Type())
+	}
+	for _, key := range sortedKeys(table) {
+		var field reflect.Value
+		if target.Kind() == reflect.Map {
+			field = reflect.New(target.Type().Elem()).Elem()
+			if existing := target.MapIndex(reflect.ValueOf(key)); existing.IsValid() {
+				field.Set(existing)
+			}
+		} else if index, ok := fields[key]; ok {
+			field = target.Field(index)
+		} else {
+			d.unknown[join(path, key)] = true
+			d.errs = append(d.errs, join(path, key)+": unknown key")
+			continue
+		}
+		value := field
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Map || (value.Kind() == reflect.Struct && value.Type() != timeType) {
+			if value.Kind() == reflect.Map && value.IsNil() {
+				value.Set(reflect.MakeMap(value.Type()))
+			}
+			nested := map[string]toml.Primitive{}
+			if err := d.metadata.PrimitiveDecode(table[key], &nested); err != nil {
+				d.errs = append(d.errs, join(path, key)+": expected a table")
+				continue
+			}
+			d.layer(nested, value, join(path, key))
+		} else if err := d.metadata.PrimitiveDecode(table[key], value.Addr().Interface()); err != nil {
+			d.errs = append(d.errs, err.Error())
+		}
+		if target.Kind() == reflect.Map {
+			target.SetMapIndex(reflect.ValueOf(key), field)
+		}
+	}
+}
+
+// inUnknown tells if the key is inside a key reported as unknown
+func (d *fileDecoder) inUnknown(key toml.Key) bool {
+	for i := range key {
+		if d.unknown[strings.Join(key[:i+1], ".")] {
+			return true
+		}
+	}
+	return false
+}
+
+// tomlFields maps the toml tags of the struct to the index of their fields, the fields without tag are skipped
+func tomlFields(t reflect.Type) map[string]int {
+	fields := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]; name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func sortedKeys(table map[string]toml.Primitive) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ValidationError has every error of the configuration, so they can be fixed at once
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks every layer applied to the configuration, returning a ValidationError with all the errors found
+func (c *Config) Validate() error {
+	errs := []string{}
+	fail := func(message string) {
+		errs = append(errs, message)
+	}
+	positive := func(name string, duration time.Duration) {
+		if duration <= 0 {
+			fail(name + " must be a positive duration")
+		}
+	}
+	if endpoint, err := url.Parse(c.Endpoints.ZapProperties); c.Endpoints.ZapProperties == "" {
+		fail("endpoints.zap_properties must be set")
+	} else if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		fail("endpoints.zap_properties must be an http or https URL")
+	}
+	if c.Server.Host == "" {
+		fail("server.host must be set")
+	}
+	if len(c.SortedDatasources()) == 0 {
+		fail("datasources must enable at least one source")
+	}
+	for _, source := range c.SortedDatasources() {
+		if _, ok := (*c.Rules)[source]; !ok {
+			fail("rules." + source + " must be set for the enabled source")
+		}
+	}
+	for _, source := range sortedNames(*c.Rules) {
+		rules := (*c.Rules)[source]
+		if rules.SaleMinPrice < 0 || rules.RentalMinPrice < 0 || rules.RentalMinSquareMeterPrice < 0 {
+			fail("rules." + source + " prices must not be negative")
+		}
+		if rules.RentalMaxCondoFeeRatio < 0 || rules.RentalMaxCondoFeeRatio > 1 {
+			fail("rules." + source + ".rental_max_condo_fee_ratio must be between 0 and 1")
+		}
+	}
+	for _, name := range sortedNames(*c.Zones) {
+		zone := (*c.Zones)[name]
+		if zone.MinLat >= zone.MaxLat || zone.MinLon >= zone.MaxLon {
+			fail("zones." + name + " minimums must be below the maximums")
+		}
+		if zone.MinLat < -90 || zone.MaxLat > 90 || zone.MinLon < -180 || zone.MaxLon > 180 {
+			fail("zones." + name + " must be inside the latitudes -90 to 90 and longitudes -180 to 180")
+		}
+	}
+	for _, source := range sortedNames(*c.Campaigns) {
+		if _, ok := (*c.Datasources)[source]; !ok {
+			fail("campaigns." + source + " is not a datasource")
+		}
+		for _, campaign := range (*c.Campaigns)[source] {
+			if _, ok := (*c.Zones)[campaign.Zone]; !ok {
+				fail("campaigns." + source + " zone " + campaign.Zone + " is not configured")
+			}
+			if campaign.BusinessType != "SALE" && campaign.BusinessType != "RENTAL" {
+				fail("campaigns." + source + ".business_type must be SALE or RENTAL")
+			}
+			if campaign.PriceMultiplier <= 0 {
+				fail("campaigns." + source + ".price_multiplier must be positive")
+			}
+		}
+	}
+	for _, source := range sortedNames(*c.Quality) {
+		quality := (*c.Quality)[source]
+		if quality.Images < 0 || quality.Location < 0 || quality.Pricing < 0 || quality.Recency < 0 {
+			fail("quality." + source + " weights must not be negative")
+		}
+		if quality.Images+quality.Location+quality.Pricing+quality.Recency == 0 {
+			fail("quality." + source + " must have a positive weight")
+		}
+		if quality.MaxImages <= 0 || quality.StaleAfter <= 0 {
+			fail("quality." + source + ".max_images and stale_after must be positive")
+		}
+	}
+	for _, name := range sortedNames(*c.Versions) {
+		version := (*c.Versions)[name]
+		if _, ok := (*c.Versions)[version.Successor]; version.Successor != "" && !ok {
+			fail("versions." + name + ".successor " + version.Successor + " is not configured")
+		}
+	}
+	positive("cache.default_expiration", c.CacheTTL.DefaultExpiration)
+	positive("cache.cleanup_interval", c.CacheTTL.CleanupInterval)
+	positive("ingestion.interval", c.Ingestion.Interval)
+	positive("ingestion.max_age", c.Ingestion.MaxAge)
+	positive("server.read_timeout", c.Server.ReadTimeout)
+	positive("server.read_header_timeout", c.Server.ReadHeaderTimeout)
+	positive("server.write_timeout", c.Server.WriteTimeout)
+	positive("server.idle_timeout", c.Server.IdleTimeout)
+	positive("server.shutdown_timeout", c.Server.ShutdownTimeout)
+	if c.Reload.WatchInterval < 0 {
+		fail("reload.watch_interval must not be negative")
+	}
+	if c.Auth.KeysFile != "" && len(c.Clients) == 0 {
+		fail("auth.keys_file must have at least one client")
+	}
+	hashes := map[string]string{}
+	for _, name := range sortedNames(c.Clients) {
+		client := c.Clients[name]
+		if hash, err := hex.DecodeString(client.KeyHash); err != nil || len(hash) != sha256.Size {
+			fail("clients." + name + ".key_hash must be the hex SHA-256 of the key")
+		}
+		if other, ok := hashes[client.KeyHash]; ok {
+			fail("clients." + name + ".key_hash is the same of the client " + other)
+		}
+		hashes[client.KeyHash] = name
+		if len(client.Sources) == 0 {
+			fail("clients." + name + ".sources must have at least one source")
+		}
+		for _, source := range client.Sources {
+			if _, ok := (*c.Datasources)[source]; !ok {
+				fail("clients." + name + " source " + source + " is not a datasource")
+			}
+		}
+	}
+	limit := func(name string, limit *Limit) {
+		if limit.RequestsPerSecond < 0 || limit.DailyQuota < 0 {
+			fail(name + " must not be negative")
+		}
+		if limit.RequestsPerSecond > 0 && limit.Burst < 1 {
+			fail(name + ".burst must be at least 1")
+		}
+	}
+	limit("rate_limit.default", c.RateLimit.Default)
+	for _, name := range sortedNames(c.RateLimit.Clients) {
+		limit("rate_limit.clients."+name, c.RateLimit.Clients[name])
+		if _, ok := c.Clients[name]; !ok {
+			fail("rate_limit.clients." + name + " is not a client of the auth.keys_file")
+		}
+	}
+	for _, route := range sortedNames(c.RateLimit.Routes) {
+		limit("rate_limit.routes."+route, c.RateLimit.Routes[route])
+		if c.RateLimit.Routes[route].DailyQuota != 0 {
+			fail("rate_limit.routes." + route + ".daily_quota is not supported, the quotas are of the clients")
+		}
+		if !strings.HasPrefix(route, "/") {
+			fail("rate_limit.routes." + route + " must be a path like /properties")
+		}
+	}
+	if c.RateLimit.MaxPageSize <= 0 {
+		fail("rate_limit.max_page_size must be positive")
+	}
+	for _, source := range sortedNames(c.CORS.Origins) {
+		if _, ok := (*c.Datasources)[source]; !ok {
+			fail("cors.origins." + source + " is not a datasource")
+		}
+		for _, origin := range c.CORS.Origins[source] {
+			if parsed, err := url.Parse(origin); origin != "*" && (err != nil || parsed.Host == "" || parsed.Path != "" ||
+				(parsed.Scheme != "http" && parsed.Scheme != "https")) {
+				fail("cors.origins." + source + " origin " + origin + " must be like https://www.zapimoveis.com.br or *")
+			}
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		fail("cors.max_age must not be negative")
+	}
+	if len(c.Export.Columns) == 0 {
+		fail("export.columns must not be empty")
+	}
+	for _, column := range c.Export.Columns {
+		if column == "" {
+			fail("export.columns must not have empty columns")
+		}
+	}
+	if c.Export.Directory == "" {
+		fail("export.directory must not be empty")
+	}
+	if c.Export.Retention <= 0 {
+		fail("export.retention must be positive")
+	}
+	if c.Export.MaxConcurrent <= 0 {
+		fail("export.max_concurrent must be positive")
+	}
+	if c.Export.MaxQueued <= 0 {
+		fail("export.max_queued must be positive")
+	}
+	if c.Changes.Retention <= 0 {
+		fail("changes.retention must be positive")
+	}
+	if c.Stream.Heartbeat <= 0 {
+		fail("stream.heartbeat must be positive")
+	}
+	if c.Searches.File == "" {
+		fail("searches.file must not be empty")
+	}
+	if c.Searches.MaxAttempts <= 0 {
+		fail("searches.max_attempts must be positive")
+	}
+	if c.Searches.Backoff <= 0 || c.Searches.Timeout <= 0 {
+		fail("searches.backoff and searches.timeout must be positive")
+	}
+	if c.Searches.History < 0 {
+		fail("searches.history must not be negative")
+	}
+	if c.Prices.File == "" {
+		fail("prices.file must not be empty")
+	}
+	if c.Prices.Retention <= 0 {
+		fail("prices.retention must be positive")
+	}
+	if c.Dedup.Distance < 0 || c.Dedup.AreaTolerance < 0 || c.Dedup.PriceTolerance < 0 || c.Dedup.MinSharedImages < 0 {
+		fail("dedup.distance, dedup.area_tolerance, dedup.price_tolerance and dedup.min_shared_images must not be negative")
+	}
+	if c.Anomalies.Threshold <= 0 || c.Anomalies.HighThreshold < c.Anomalies.Threshold {
+		fail("anomalies.threshold must be positive and anomalies.high_threshold not below it")
+	}
+	if c.Anomalies.MinGroupSize < 3 {
+		fail("anomalies.min_group_size must be at least 3")
+	}
+	if q := c.Anomalies.Quarantine; q != "" && q != "medium" && q != "high" {
+		fail("anomalies.quarantine must be medium, high or empty")
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		fail("server.max_header_bytes must be positive")
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		fail("logging.format must be json or text")
+	}
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		fail("logging.level " + c.Logging.Level + " is not a level")
+	}
+	if c.Tracing.Exporter != "" && c.Tracing.Exporter != "otlp" && c.Tracing.Exporter != "stdout" {
+		fail("tracing.exporter must be otlp, stdout or empty")
+	}
+	if len(errs) > 0 {
+		return ValidationError(errs)
+	}
+	return nil
+}
+
+// sortedNames returns the keys of a map of the configuration in order, so the errors are always in the same order
+func sortedNames(table interface{}) []string {
+	names := []string{}
+	for _, key := range reflect.ValueOf(table).MapKeys() {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return names
+}
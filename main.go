@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"gitlab.com/zap-api/app"
@@ -8,8 +9,18 @@ import (
 )
 
 func main() {
-	config := config.GetConfig()
+	config, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if config.PrintConfig {
+		fmt.Print(config.TOML())
+		return
+	}
 	app := &app.App{}
-	app.Initialize(config)
-	app.Run(os.Getenv("HOST"))
+	if err := app.Initialize(config); err != nil {
+		os.Exit(1)
+	}
+	app.Run(config.Server.Host)
 }
@@ -1,14 +1,37 @@
 package main_test
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"gitlab.com/zap-api/app"
+	"gitlab.com/zap-api/app/handler"
+	"gitlab.com/zap-api/app/model"
+	"gitlab.com/zap-api/app/openapi"
+	"gitlab.com/zap-api/app/tracing"
 	"gitlab.com/zap-api/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 var a app.App
@@ -16,6 +39,16 @@ var a app.App
 func init() {
 	os.Setenv("HOST", ":8080")
 	os.Setenv("ZAP_PROPERTIES_ENDPOINT", "http://grupozap-code-challenge.s3-website-us-east-1.amazonaws.com/sources/source-2.json")
+	// The files of the API are written to a temporary directory, not to the data of the working directory
+	data, err := ioutil.TempDir("", "zap-api-data")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("EXPORT_DIRECTORY", filepath.Join(data, "exports"))
+	os.Setenv("SEARCHES_FILE", filepath.Join(data, "searches.json"))
+	// The webhooks of the tests are served on the loopback
+	os.Setenv("SEARCHES_ALLOW_PRIVATE_WEBHOOKS", "true")
+	os.Setenv("PRICES_FILE", filepath.Join(data, "prices.json"))
 	config := config.GetConfig()
 	a.Initialize(config)
 }
@@ -68,3 +101,1654 @@ func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	handler.ServeHTTP(rr, req)
 	return rr
 }
+
+// TestFacets tests the facet counts over the filtered Properties
+func TestFacets(t *testing.T) {
+	defer useFixture(t)()
+	req, err := http.NewRequest("GET", "/properties?businessType=SALE&facets=bedrooms,neighborhood", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+
+	response := executeRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := model.ListPropertyResponse{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, 3, body.PropertiesTotalCount)
+	assert.Equal(t, []model.FacetCount{{Value: "3", Count: 2}, {Value: "2", Count: 1}}, body.Facets["bedrooms"])
+	assert.Equal(t, []model.FacetCount{{Value: "Moema", Count: 2}, {Value: "Jardim América", Count: 1}}, body.Facets["neighborhood"])
+}
+
+// TestWrongFacet tests the API for a facet that is not accepted
+func TestWrongFacet(t *testing.T) {
+	req, err := http.NewRequest("GET", "/properties?facets=owner", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+
+	response := executeRequest(req)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, response.Body.String(), `{"error":"Facet not accepted: owner."}`)
+}
+
+// TestAutocomplete tests the accent insensitive suggestions with the count of every source
+func TestAutocomplete(t *testing.T) {
+	defer useFixture(t)()
+	req, err := http.NewRequest("GET", "/autocomplete?q=sao+pau", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := model.AutocompleteResponse{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, []model.Suggestion{{
+		Text:       "São Paulo",
+		Type:       "city",
+		Counts:     map[string]int{"zap": 3, "vivareal": 2},
+		TotalCount: 5,
+	}}, body.Suggestions)
+}
+
+// TestPropertiesV2 tests the money fields in cents after the ZAP discount
+func TestPropertiesV2(t *testing.T) {
+	defer useFixture(t)()
+	req, err := http.NewRequest("GET", "/v2/properties?q=jardim+america", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := model.ListPropertyResponseV2{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Len(t, body.Properties, 1)
+	property := body.Properties[0]
+	assert.Equal(t, &model.Money{Amount: 70000000, Currency: "BRL"}, property.PricingInfos.Price)
+	assert.Equal(t, &model.Money{Amount: 1000000, Currency: "BRL"}, property.PricePerSquareMeter)
+	assert.Equal(t, &model.Money{Amount: 60000, Currency: "BRL"}, property.TotalMonthlyCost)
+}
+
+// TestDeprecatedVersion tests the v1 is supported by default, and deprecated with its sunset in the headers and the document
+func TestDeprecatedVersion(t *testing.T) {
+	for _, path := range []string{"/properties", "/v1/properties", "/v2/properties"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response := executeRouterRequest(req)
+
+		assert.Equal(t, http.StatusNotFound, response.Code)
+		assert.Equal(t, "", response.Header().Get("Deprecation"), path)
+		assert.False(t, a.Document.Operation(path, "GET").Deprecated, path)
+	}
+
+	deprecated, err := config.Load([]string{"--v1-sunset", "2030-01-01T00:00:00Z"})
+	assert.Nil(t, err)
+	document := openapi.NewDocument(deprecated.SortedDatasources(), false, *deprecated.Versions)
+	for path, deprecation := range map[string]bool{"/properties": true, "/v1/properties": true, "/v2/properties": false} {
+		assert.Equal(t, deprecation, document.Operation(path, "GET").Deprecated, path)
+	}
+}
+
+// TestInvalidParameter tests the uniform error for the parameters not matching the OpenAPI document
+func TestInvalidParameter(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v2/properties?limit=ten", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, response.Body.String(), `{"error":"Invalid request: parameter limit must be an integer."}`)
+
+	// The source header is validated by the document too, before the query parameters following it
+	req.Header.Set("source", "xxx")
+
+	response = executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Equal(t, response.Body.String(), `{"error":"Source not accepted."}`)
+}
+
+// TestOpenAPIRoutes tests every route of the router is described by the OpenAPI document
+func TestOpenAPIRoutes(t *testing.T) {
+	err := a.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			assert.NotNil(t, a.Document.Operation(path, method), method+" "+path)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+// TestOpenAPIContract tests the responses of the handlers against the OpenAPI document
+func TestOpenAPIContract(t *testing.T) {
+	defer useFixture(t)()
+	for _, request := range []struct {
+		path   string
+		route  string
+		source string
+	}{
+		{"/properties?facets=bedrooms", "/properties", "zap"},
+		{"/v1/properties?offset=1&limit=2", "/v1/properties", "vivareal"},
+		{"/v2/properties?facets=priceRange,businessType", "/v2/properties", "zap"},
+		{"/v2/properties", "/v2/properties", "xxx"},
+		{"/v2/properties?offset=100", "/v2/properties", "zap"},
+		{"/v2/autocomplete?q=moe", "/v2/autocomplete", ""},
+		{"/v2/export/zap?format=json", "/v2/export/{source}", ""},
+		{"/export/xxx", "/export/{source}", ""},
+		{"/autocomplete?q=", "/autocomplete", ""},
+	} {
+		req, err := http.NewRequest("GET", request.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", request.source)
+
+		response := executeRouterRequest(req)
+
+		operation := a.Document.Operation(request.route, "GET")
+		assert.NotNil(t, operation, request.route)
+		assert.Nil(t, a.Document.ValidateResponse(operation, response.Code, response.Body.Bytes()), request.path)
+	}
+}
+
+// TestMetrics tests the metrics of the requests, the cache and the ingestion
+func TestMetrics(t *testing.T) {
+	defer useFixture(t)()
+	req, err := http.NewRequest("GET", "/v2/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	executeRouterRequest(req)
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	assert.Contains(t, body, `zap_http_requests_total{route="/v2/properties",source="zap",status="200"}`)
+	assert.Contains(t, body, `zap_cache_requests_total{result="miss",source="zap"}`)
+	assert.Contains(t, body, `zap_ingestion_rejected_total{rule="no_location",source="vivareal"}`)
+	assert.Contains(t, body, `zap_dataset_age_seconds{source="zap"}`)
+	assert.Contains(t, body, `go_goroutines`)
+	assert.Contains(t, body, `go_memstats_alloc_bytes`)
+}
+
+// TestRequestID tests the ID of the request is answered back, or generated when it is not informed
+func TestRequestID(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v2/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "abc-123")
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, "abc-123", response.Header().Get("X-Request-ID"))
+
+	req.Header.Del("X-Request-ID")
+	response = executeRouterRequest(req)
+
+	assert.Len(t, response.Header().Get("X-Request-ID"), 32)
+}
+
+// TestLogLevel tests the level of the Logger is changed while the API is running
+func TestLogLevel(t *testing.T) {
+	level := a.Config.Logger.GetLevel()
+	defer a.Config.Logger.SetLevel(level)
+	defer useAdminKey(t)()
+	req, err := http.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"debug"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"level":"debug"}`, response.Body.String())
+	assert.Equal(t, logrus.DebugLevel, a.Config.Logger.GetLevel())
+
+	req, err = http.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"loud"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+
+	response = executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, `{"error":"Level not accepted."}`, response.Body.String())
+}
+
+// TestTracing tests the Spans of a request without cache, and the traceparent sent to the upstream
+func TestTracing(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	tracing.SetExporter(spans, "zap-api", false)
+	defer tracing.SetExporter(nil, "zap-api", false)
+	traceParent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		http.ServeFile(w, r, "testdata/properties.json")
+	}))
+	defer server.Close()
+	defer useFixture(t)()
+	a.Config.Endpoints.ZapProperties = server.URL
+	req, err := http.NewRequest("GET", "/v2/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$", traceParent)
+	names := []string{}
+	for _, span := range spans.GetSpans() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"cache lookup", "upstream fetch", "decode JSON", "evaluate rules", "serialize response", "GET /v2/properties"}, names)
+
+	// The Spans not exported are counted in the metrics
+	tracing.SetExporter(failingExporter{}, "zap-api", false)
+	_, span := tracing.Start(context.Background(), "lost")
+	span.End()
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = executeRouterRequest(req)
+
+	assert.Regexp(t, `zap_tracing_errors_total [1-9]`, response.Body.String())
+}
+
+// failingExporter never sends the Spans
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return errors.New("collector not reachable")
+}
+
+func (failingExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// TestReadiness tests the API is ready just after the snapshots of every source are loaded
+func TestReadiness(t *testing.T) {
+	defer useFixture(t)()
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/readyz", "GET"), response.Code, response.Body.Bytes()))
+
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	response = executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	readiness := model.Readiness{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &readiness))
+	assert.Equal(t, 4, readiness.Sources["zap"].Properties)
+	assert.Equal(t, "ready", readiness.Sources["vivareal"].Status)
+	assert.NotZero(t, readiness.Sources["vivareal"].SnapshotVersion)
+}
+
+// TestServe tests the server stops when the context is done, after the ingestion is cancelled
+func TestServe(t *testing.T) {
+	defer useFixture(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.Serve(ctx, "127.0.0.1:0")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	_, found := a.Config.Cache.Get("zap")
+	assert.True(t, found)
+}
+
+// TestConfigValidation tests every error of the configuration is returned at once
+func TestConfigValidation(t *testing.T) {
+	_, err := config.Load([]string{"--log-format", "xml", "--ingestion-interval", "soon", "--datasources", "zap,olx"})
+
+	assert.Equal(t, config.ValidationError{
+		"--ingestion-interval: expected a duration like \"5m\", found soon",
+		"rules.olx must be set for the enabled source",
+		"campaigns.vivareal is not a datasource",
+		"logging.format must be json or text",
+	}, err)
+}
+
+// TestConfigFile tests the file is layered over the defaults of the tables of every source, and its unknown keys
+func TestConfigFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "zap-api-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("[rules.zap]\nsale_min_price = 1000\n\n[versions.v1]\nsunset = 2030-01-01T00:00:00Z\n\n[ingestion]\ninterval = \"7m\"\n")
+	file.Close()
+
+	loaded, err := config.Load([]string{"--config", file.Name()})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, (*loaded.Rules)["zap"].SaleMinPrice)
+	assert.Equal(t, 3500, (*loaded.Rules)["zap"].RentalMinPrice)
+	assert.Equal(t, "v2", (*loaded.Versions)["v1"].Successor)
+	assert.Equal(t, 7*time.Minute, loaded.Ingestion.Interval)
+
+	ioutil.WriteFile(file.Name(), []byte("[rules.zap]\ncolour = \"red\"\n\n[[campaigns.zap]]\nshape = 1\n\n[unknown]\nkey = 1\n"), 0644)
+
+	_, err = config.Load([]string{"--config", file.Name()})
+
+	assert.Equal(t, config.ValidationError{
+		file.Name() + ": rules.zap.colour: unknown key",
+		file.Name() + ": unknown: unknown key",
+		file.Name() + ": campaigns.zap.shape: unknown key",
+	}, err)
+}
+
+// TestReload tests the rules are applied to the last feed on the reloads, and an invalid configuration is rejected
+func TestReload(t *testing.T) {
+	defer useFixture(t)()
+	file, restore := useConfigFile(t)
+	defer restore()
+	defer useAdminKey(t)()
+	req, err := http.NewRequest("GET", "/v2/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	req.Header.Set("X-API-Key", adminKey)
+	assert.Equal(t, http.StatusOK, executeRouterRequest(req).Code)
+
+	ioutil.WriteFile(file, []byte("[rules.zap]\nsale_min_price = 10000000\n"), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+	// The feed is not downloaded again, the upstream is not reachable anymore
+	a.Config.Endpoints.ZapProperties = "http://localhost:1"
+	response := executeRouterRequest(req)
+
+	properties := model.ListPropertyResponseV2{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+	assert.Equal(t, 1, properties.PropertiesTotalCount)
+
+	ioutil.WriteFile(file, []byte("[logging]\nformat = \"xml\"\n"), 0644)
+	assert.NotNil(t, a.Reload(context.Background()))
+	statusReq, err := http.NewRequest("GET", "/admin/config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusReq.Header.Set("X-API-Key", adminKey)
+	response = executeRouterRequest(statusReq)
+
+	status := model.ConfigStatus{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Equal(t, file, status.File)
+	assert.Equal(t, []string{"logging.format must be json or text"}, status.Errors)
+	assert.Equal(t, "json", a.Config.Logging.Format)
+	assert.Nil(t, json.Unmarshal(executeRouterRequest(req).Body.Bytes(), &properties))
+	assert.Equal(t, 1, properties.PropertiesTotalCount)
+
+	// The reloads of the SIGHUP and of the watch of the file run one at a time
+	ioutil.WriteFile(file, []byte("[rules.zap]\nsale_min_price = 10000000\n"), 0644)
+	reloads := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		reloads.Add(1)
+		go func() {
+			defer reloads.Done()
+			assert.Nil(t, a.Reload(context.Background()))
+		}()
+	}
+	reloads.Wait()
+	reloaded := model.ConfigStatus{}
+	assert.Nil(t, json.Unmarshal(executeRouterRequest(statusReq).Body.Bytes(), &reloaded))
+	assert.Equal(t, status.Reloads+4, reloaded.Reloads)
+	assert.Empty(t, reloaded.Errors)
+}
+
+// TestSources tests a source added by the configuration, and the campaigns of a source not changing the others
+func TestSources(t *testing.T) {
+	defer useFixture(t)()
+	file, restore := useConfigFile(t)
+	defer restore()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	ioutil.WriteFile(file, []byte(`
+[datasources]
+olx = true
+
+[rules.olx]
+sale_min_price = 1000000
+rental_min_price = 1000
+
+[zones.grupozap]
+min_lon = -47.0
+min_lat = -24.0
+max_lon = -46.0
+max_lat = -23.0
+`), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+
+	prices := map[string]map[string]string{}
+	for _, source := range []string{"zap", "vivareal", "olx"} {
+		req, err := http.NewRequest("GET", "/properties", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", source)
+		response := executeRouterRequest(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		properties := model.ListPropertyResponse{}
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+		prices[source] = map[string]string{}
+		for _, property := range properties.Properties {
+			prices[source][property.Id] = property.PricingInfos.Price
+		}
+	}
+	assert.Equal(t, map[string]string{"a3": "1500000", "a4": "5000"}, prices["olx"])
+	assert.Equal(t, "630000.000000", prices["zap"]["a1"])
+	assert.Equal(t, "700000", prices["vivareal"]["a1"])
+
+	defer useAdminKey(t)()
+	req, err := http.NewRequest("GET", "/admin/sources", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/admin/sources", "GET"), response.Code, response.Body.Bytes()))
+	sources := model.Sources{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &sources))
+	assert.Equal(t, 3, len(sources.Sources))
+	assert.Equal(t, "olx", sources.Sources[0].Name)
+	assert.Equal(t, 2, sources.Sources[0].Properties)
+	assert.Equal(t, -24.0, sources.Sources[2].Campaigns[0].BoundingBox.MinLat)
+}
+
+// TestAPIKeys tests the clients are authenticated by their keys and read just their sources
+func TestAPIKeys(t *testing.T) {
+	defer useFixture(t)()
+	file, restore := useConfigFile(t)
+	defer restore()
+	keys := file + ".keys"
+	defer os.Remove(keys)
+	ioutil.WriteFile(keys, []byte(`
+[clients.crm]
+key_hash = "`+hashKey("crm-key")+`"
+sources = ["vivareal"]
+
+[clients.ops]
+key_hash = "`+hashKey("ops-key")+`"
+sources = ["zap"]
+admin = true
+`), 0644)
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	ioutil.WriteFile(file, []byte("[auth]\nkeys_file = \""+keys+"\"\n"), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+
+	for _, test := range []struct {
+		path   string
+		header string
+		key    string
+		source string
+		status int
+	}{
+		{"/v2/properties", "", "", "", http.StatusUnauthorized},
+		{"/v2/properties", "Authorization", "Bearer wrong-key", "", http.StatusUnauthorized},
+		{"/v2/properties", "Authorization", "Bearer crm-key", "", http.StatusOK},
+		{"/v2/properties", "X-API-Key", "crm-key", "vivareal", http.StatusOK},
+		{"/v2/properties", "X-API-Key", "crm-key", "zap", http.StatusForbidden},
+		{"/admin/sources", "X-API-Key", "crm-key", "", http.StatusForbidden},
+		{"/admin/sources", "Authorization", "Bearer ops-key", "", http.StatusOK},
+		{"/healthz", "", "", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.header != "" {
+			req.Header.Set(test.header, test.key)
+		}
+		if test.source != "" {
+			req.Header.Set("source", test.source)
+		}
+		response := executeRouterRequest(req)
+
+		assert.Equal(t, test.status, response.Code, test.path+" "+test.key+" "+test.source)
+		assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation(test.path, "GET"), response.Code, response.Body.Bytes()))
+		if test.status == http.StatusOK && test.path == "/v2/properties" {
+			properties := model.ListPropertyResponseV2{}
+			assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+			assert.Equal(t, 3, properties.PropertiesTotalCount)
+		}
+	}
+}
+
+// TestAdminWithoutKeys tests the administration is closed while the API keys are not configured
+func TestAdminWithoutKeys(t *testing.T) {
+	req, err := http.NewRequest("GET", "/admin/sources", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+	response := executeRouterRequest(req)
+
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Equal(t, `{"error":"Administration not allowed without the API keys."}`, response.Body.String())
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/admin/sources", "GET"), response.Code, response.Body.Bytes()))
+}
+
+// TestRateLimit tests the buckets of the client and of the route, and the quotas of the day
+func TestRateLimit(t *testing.T) {
+	defer useFixture(t)()
+	file, restore := useConfigFile(t)
+	defer restore()
+	handler.ResetRateLimits()
+	defer handler.ResetRateLimits()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	ioutil.WriteFile(file, []byte(`
+[rate_limit]
+max_page_size = 2
+trust_forwarded_for = true
+
+[rate_limit.default]
+requests_per_second = 0.001
+burst = 3
+daily_quota = 100
+
+[rate_limit.routes."/autocomplete"]
+requests_per_second = 0.001
+burst = 1
+`), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+
+	for i, test := range []struct {
+		path      string
+		status    int
+		limit     string
+		remaining string
+	}{
+		{"/v2/properties?limit=50", http.StatusOK, "3", "2"},
+		{"/v2/autocomplete?q=sao", http.StatusOK, "1", "0"},
+		{"/autocomplete?q=sao", http.StatusTooManyRequests, "1", "0"},
+		{"/v1/properties", http.StatusOK, "3", "0"},
+		{"/v2/properties", http.StatusTooManyRequests, "3", "0"},
+	} {
+		req, err := http.NewRequest("GET", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The proxy adds the IP of the client after the addresses forged by it
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i)+", 203.0.113.7")
+		req.Header.Set("source", "zap")
+		response := executeRouterRequest(req)
+
+		assert.Equal(t, test.status, response.Code, test.path)
+		assert.Equal(t, test.limit, response.Header().Get("RateLimit-Limit"), test.path)
+		assert.Equal(t, test.remaining, response.Header().Get("RateLimit-Remaining"), test.path)
+		if test.status == http.StatusTooManyRequests {
+			assert.Equal(t, `{"error":"Too many requests."}`, response.Body.String())
+			assert.NotEmpty(t, response.Header().Get("Retry-After"))
+		}
+		if test.path == "/v2/properties?limit=50" {
+			properties := model.ListPropertyResponseV2{}
+			assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+			assert.Equal(t, 2, properties.PageSize)
+			assert.Equal(t, 2, len(properties.Properties))
+		}
+	}
+
+	defer useAdminKey(t)()
+	req, err := http.NewRequest("GET", "/admin/quotas", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", adminKey)
+	response := executeRouterRequest(req)
+
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/admin/quotas", "GET"), response.Code, response.Body.Bytes()))
+	quotas := model.Quotas{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &quotas))
+	assert.Contains(t, quotas.Clients, model.ClientQuota{Client: "ip:203.0.113.7", Requests: 3, Quota: 100})
+	for _, quota := range quotas.Clients {
+		assert.NotZero(t, quota.Quota, quota.Client)
+	}
+}
+
+// TestBrowserClients tests the CORS of the origins of the source, the compression and the conditional requests
+func TestBrowserClients(t *testing.T) {
+	defer useFixture(t)()
+	file, restore := useConfigFile(t)
+	defer restore()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	ioutil.WriteFile(file, []byte("[cors.origins]\nzap = [\"https://www.zapimoveis.com.br\"]\n"), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+
+	preflight, err := http.NewRequest("OPTIONS", "/v2/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preflight.Header.Set("Origin", "https://www.zapimoveis.com.br")
+	preflight.Header.Set("Access-Control-Request-Method", "GET")
+	preflight.Header.Set("Access-Control-Request-Headers", "source")
+	response := executeHandlerRequest(preflight)
+
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, "https://www.zapimoveis.com.br", response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, response.Header().Get("Access-Control-Allow-Headers"), "Source")
+
+	request := func(source, path string, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		req.Header.Set("Origin", "https://www.zapimoveis.com.br")
+		req.Header.Set("source", source)
+		return executeHandlerRequest(req)
+	}
+	response = request("zap", "/v2/properties?limit=2", http.Header{"Accept-Encoding": {"br, gzip;q=0.8"}})
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "https://www.zapimoveis.com.br", response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "br", response.Header().Get("Content-Encoding"))
+	properties := model.ListPropertyResponseV2{}
+	assert.Nil(t, json.NewDecoder(brotli.NewReader(response.Body)).Decode(&properties))
+	assert.Equal(t, 2, len(properties.Properties))
+	etag := response.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// The coding of the highest quality is chosen, the "*" is the quality of the codings not named
+	response = request("zap", "/v2/properties?limit=2", http.Header{"Accept-Encoding": {"br;q=0.5, gzip"}})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+	body, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, json.NewDecoder(body).Decode(&properties))
+	assert.Equal(t, 2, len(properties.Properties))
+	response = request("zap", "/v2/properties?limit=2", http.Header{"Accept-Encoding": {"br;q=0, *;q=0.1"}})
+	assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+	response = request("zap", "/v2/properties?limit=2", http.Header{"Accept-Encoding": {"identity, deflate"}})
+	assert.Empty(t, response.Header().Get("Content-Encoding"))
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+	assert.Equal(t, 2, len(properties.Properties))
+
+	response = request("zap", "/v2/properties?limit=2", http.Header{"If-None-Match": {etag}, "Accept-Encoding": {"gzip"}})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+	assert.Empty(t, response.Body.String())
+	assert.Empty(t, response.Header().Get("Content-Encoding"))
+
+	response = request("zap", "/v2/properties?limit=3", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotEqual(t, etag, response.Header().Get("ETag"))
+
+	response = request("zap", "/v2/properties?limit=2", http.Header{"If-Modified-Since": {response.Header().Get("Last-Modified")}})
+	assert.Equal(t, http.StatusNotModified, response.Code)
+
+	response = request("vivareal", "/v2/properties", nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// TestExport tests the export of every Property of the source, in the three formats
+func TestExport(t *testing.T) {
+	defer useFixture(t)()
+	request := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeRouterRequest(req)
+	}
+
+	response := request("/v2/export/zap")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/x-ndjson", response.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="zap.ndjson"`, response.Header().Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	assert.Len(t, lines, 4)
+	property := model.PropertyV2{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &property))
+	assert.NotEmpty(t, property.Id)
+
+	response = request("/v2/export/zap?format=csv&businessType=RENTAL&columns=id,address.neighborhood,pricingInfos.price,images")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", response.Header().Get("Content-Type"))
+	rows := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "id,address.neighborhood,pricingInfos.price,images", rows[0])
+	assert.True(t, strings.HasPrefix(rows[1], "a4,"), rows[1])
+	assert.Contains(t, rows[1], ",5000.00,")
+
+	response = request("/export/vivareal?format=csv")
+	rows = strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	assert.Len(t, rows, 4)
+	assert.Equal(t, strings.Join(a.Config.Export.Columns, ","), rows[0])
+
+	response = request("/v1/export/vivareal?format=json&minPrice=1000000")
+	assert.Equal(t, http.StatusOK, response.Code)
+	properties := []model.Property{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &properties))
+	assert.Len(t, properties, 1)
+	assert.Equal(t, "a3", properties[0].Id)
+
+	assert.Equal(t, http.StatusBadRequest, request("/v2/export/zap?format=xml").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/v2/export/zap?format=csv&columns=id,address.street").Code)
+	assert.Equal(t, http.StatusNotFound, request("/v2/export/xxx").Code)
+}
+
+// TestExportJobs tests the export jobs, from the creation to the download of the artifact
+func TestExportJobs(t *testing.T) {
+	defer useFixture(t)()
+	directory, err := ioutil.TempDir("", "zap-api-exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	previous := a.Config.Export.Directory
+	a.Config.Export.Directory = directory
+	defer func() { a.Config.Export.Directory = previous }()
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return executeRouterRequest(req)
+	}
+
+	response := request("POST", "/v2/exports", `{"source":"zap","format":"csv","columns":["id","pricingInfos.price"],"filters":{"businessType":"SALE"}}`)
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	job := model.ExportJob{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &job))
+	assert.Equal(t, "/v2/exports/"+job.Id, response.Header().Get("Location"))
+	for i := 0; i < 100 && job.Status != "succeeded"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		response = request("GET", "/v2/exports/"+job.Id, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &job))
+	}
+	assert.Equal(t, "succeeded", job.Status)
+	assert.Equal(t, 3, job.Properties)
+	assert.Equal(t, "/v2/exports/"+job.Id+"/download", job.Download)
+
+	response = request("GET", job.Download, "")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/gzip", response.Header().Get("Content-Type"))
+	body, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv, err := ioutil.ReadAll(body)
+	assert.Nil(t, err)
+	rows := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	assert.Len(t, rows, 4)
+	assert.Equal(t, "id,pricingInfos.price", rows[0])
+
+	assert.Equal(t, http.StatusConflict, request("DELETE", "/v2/exports/"+job.Id, "").Code)
+	assert.Equal(t, http.StatusNotFound, request("GET", "/v2/exports/unknown", "").Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/v2/exports", `{"source":"zap","format":"xml"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/v2/exports", `{"source":"zap","filters":{"owner":"true"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/v2/exports", `{"source":"xxx"}`).Code)
+
+	// The jobs wait for a slot up to the max_queued, and the ones left are cancelled on the shutdown
+	maxConcurrent, maxQueued := a.Config.Export.MaxConcurrent, a.Config.Export.MaxQueued
+	a.Config.Export.MaxConcurrent, a.Config.Export.MaxQueued = 0, 1
+	defer func() { a.Config.Export.MaxConcurrent, a.Config.Export.MaxQueued = maxConcurrent, maxQueued }()
+	response = request("POST", "/v2/exports", `{"source":"zap"}`)
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &job))
+	assert.Equal(t, "queued", job.Status)
+	response = request("POST", "/v2/exports", `{"source":"zap"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "30", response.Header().Get("Retry-After"))
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/v2/exports", "POST"), response.Code, response.Body.Bytes()))
+	assert.Nil(t, handler.StopExports(context.Background()))
+	assert.Nil(t, json.Unmarshal(request("GET", "/v2/exports/"+job.Id, "").Body.Bytes(), &job))
+	assert.Equal(t, "cancelled", job.Status)
+}
+
+// TestChanges tests the changes of the Properties between two ingestions
+func TestChanges(t *testing.T) {
+	file, restore := useConfigFile(t)
+	defer restore()
+	// The campaigns of the zone are applied to the Properties of the fixture
+	ioutil.WriteFile(file, []byte("[zones.grupozap]\nmin_lon = -47.0\nmin_lat = -24.0\nmax_lon = -46.0\nmax_lat = -23.0\n"), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+	defer useFixture(t)()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(path string) (*httptest.ResponseRecorder, model.ChangesResponse) {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := executeRouterRequest(req)
+		changes := model.ChangesResponse{}
+		json.Unmarshal(response.Body.Bytes(), &changes)
+		return response, changes
+	}
+	response, changes := request("/v2/changes?source=zap")
+	assert.Equal(t, http.StatusOK, response.Code)
+	since := changes.SnapshotVersion
+
+	// The same Properties ingested again have no change, the campaigns set their updatedAt in another second
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	response, changes = request("/v2/changes?source=zap&since=" + strconv.FormatInt(since, 10))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.True(t, changes.SnapshotVersion > since)
+	assert.Equal(t, 0, changes.ChangesTotalCount)
+
+	server := serveChangedFixture(t)
+	defer server.Close()
+	a.Config.Endpoints.ZapProperties = server.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+
+	response, changes = request("/v2/changes?source=zap&since=" + strconv.FormatInt(since, 10))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.True(t, changes.SnapshotVersion > since)
+	assert.Equal(t, 3, changes.ChangesTotalCount)
+	assert.Equal(t, []string{"a1", "a2", "a6"}, []string{changes.Changes[0].Id, changes.Changes[1].Id, changes.Changes[2].Id})
+	assert.Equal(t, "changed", changes.Changes[0].Type)
+	assert.Equal(t, model.FieldChange{From: "630000.000000", To: "648000.000000"}, changes.Changes[0].Fields["pricingInfos.price"])
+	assert.Equal(t, "removed", changes.Changes[1].Type)
+	assert.Equal(t, "added", changes.Changes[2].Type)
+
+	response, changes = request("/v2/changes?source=zap&limit=1&offset=1&since=" + strconv.FormatInt(since, 10))
+	assert.Len(t, changes.Changes, 1)
+	assert.Equal(t, "a2", changes.Changes[0].Id)
+
+	response, changes = request("/v2/changes?source=zap&since=" + strconv.FormatInt(changes.SnapshotVersion, 10))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Empty(t, changes.Changes)
+
+	response, _ = request("/v2/changes?source=zap&since=1")
+	assert.Equal(t, http.StatusGone, response.Code)
+}
+
+// TestHugeOffset tests an offset multiplied by the limit past the integers is refused by the document
+// and answers an empty page, not a panic, from the handlers
+func TestHugeOffset(t *testing.T) {
+	defer useFixture(t)()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	for _, request := range []struct {
+		path    string
+		handler func(config *config.Config, w http.ResponseWriter, r *http.Request)
+	}{
+		{"/v2/properties?offset=100000000000000000&limit=100", handler.GetAllProperties},
+		{"/v2/changes?source=zap&offset=100000000000000000&limit=100", handler.GetChanges},
+	} {
+		req, err := http.NewRequest("GET", request.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", "zap")
+
+		response := executeRouterRequest(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code, request.path)
+
+		response = httptest.NewRecorder()
+		request.handler(a.Config, response, req)
+		assert.Equal(t, http.StatusOK, response.Code, request.path)
+		assert.NotContains(t, response.Body.String(), `"id"`, request.path)
+	}
+}
+
+// TestInterleavedIngestions tests a feed requested before the last one evaluated does not replace its snapshots
+func TestInterleavedIngestions(t *testing.T) {
+	defer useFixture(t)()
+	fixture, err := ioutil.ReadFile("testdata/properties.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested, release := make(chan struct{}, 1), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write(fixture)
+	}))
+	defer slow.Close()
+	changed := serveChangedFixture(t)
+	defer changed.Close()
+	older := *a.Config
+	older.Endpoints = &config.Endpoint{ZapProperties: slow.URL}
+	done := make(chan error)
+	go func() { done <- handler.Ingest(context.Background(), &older) }()
+	<-requested
+
+	a.Config.Endpoints.ZapProperties = changed.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	close(release)
+	assert.Nil(t, <-done)
+
+	req, err := http.NewRequest("GET", "/v2/properties?limit=20", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	properties := model.ListPropertyResponseV2{}
+	assert.Nil(t, json.Unmarshal(executeRouterRequest(req).Body.Bytes(), &properties))
+	ids := []string{}
+	for _, property := range properties.Properties {
+		ids = append(ids, property.Id)
+	}
+	assert.Contains(t, ids, "a6")
+	assert.NotContains(t, ids, "a2")
+}
+
+// TestPriceHistory tests the prices recorded on every ingestion, and the filters by the last price change
+func TestPriceHistory(t *testing.T) {
+	defer useFixture(t)()
+	directory, err := ioutil.TempDir("", "zap-api-prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	file := a.Config.Prices.File
+	a.Config.Prices.File = filepath.Join(directory, "prices.json")
+	defer func() { a.Config.Prices.File = file }()
+	fixture := a.Config.Endpoints.ZapProperties
+	server := serveChangedFixture(t)
+	defer server.Close()
+	before := time.Now().UTC().Add(-time.Second).Format(time.RFC3339)
+	for _, endpoint := range []string{fixture, server.URL, fixture} {
+		a.Config.Endpoints.ZapProperties = endpoint
+		assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	}
+	request := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", "zap")
+		return executeRouterRequest(req)
+	}
+
+	response := request("/v2/properties/a1/history")
+	assert.Equal(t, http.StatusOK, response.Code)
+	history := model.PriceHistory{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &history))
+	assert.Equal(t, "zap", history.Source)
+	assert.Len(t, history.Prices, 3)
+	amounts := []int64{}
+	for _, point := range history.Prices {
+		amounts = append(amounts, point.Price.Amount)
+		assert.Equal(t, point.Price.Amount, point.AdjustedPrice.Amount)
+	}
+	assert.Equal(t, []int64{70000000, 72000000, 70000000}, amounts)
+
+	saved, err := ioutil.ReadFile(a.Config.Prices.File)
+	assert.Nil(t, err)
+	assert.Contains(t, string(saved), `"a1"`)
+
+	response = request("/v2/properties?priceDropped=true")
+	listing := model.ListPropertyResponseV2{}
+	json.Unmarshal(response.Body.Bytes(), &listing)
+	assert.Equal(t, 1, listing.PropertiesTotalCount)
+	assert.Equal(t, "a1", listing.Properties[0].Id)
+
+	response = request("/v2/properties?priceChangedSince=" + before)
+	json.Unmarshal(response.Body.Bytes(), &listing)
+	assert.Equal(t, 1, listing.PropertiesTotalCount)
+	response = request("/v2/properties?priceChangedSince=2999-01-01")
+	json.Unmarshal(response.Body.Bytes(), &listing)
+	assert.Equal(t, 0, listing.PropertiesTotalCount)
+
+	assert.Equal(t, http.StatusNotFound, request("/v2/properties/unknown/history").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/v2/properties?priceDropped=maybe").Code)
+	response = request("/v1/properties?priceChangedSince=garbage")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Equal(t, `{"error":"Invalid request: parameter priceChangedSince must be a time of RFC 3339 or a date."}`, response.Body.String())
+}
+
+// TestDuplicates tests the groups of the probable duplicates, by proximity and by the images shared
+func TestDuplicates(t *testing.T) {
+	defer useFixture(t)()
+	fixture, err := ioutil.ReadFile("testdata/properties.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := []model.Property{}
+	assert.Nil(t, json.Unmarshal(fixture, &properties))
+	nearby, sharingImages, otherPrice := properties[2], properties[2], properties[2]
+	nearby.Id, nearby.Images = "a7", []string{"http://img.example.com/a7-1.jpg"}
+	nearby.PricingInfos.Price = "1520000"
+	nearby.Address.GeoLocation.Location.Lat = -23.61009
+	sharingImages.Id = "a8"
+	sharingImages.Images = []string{"http://img.example.com/a3-1.jpg", "http://img.example.com/a3-2.jpg"}
+	sharingImages.Address.GeoLocation.Location = model.Location{Lat: -23.5, Lon: -46.5}
+	otherPrice.Id, otherPrice.Images = "a9", nil
+	otherPrice.PricingInfos.Price = "2000000"
+	properties = append(properties, nearby, sharingImages, otherPrice)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(properties)
+	}))
+	defer server.Close()
+	a.Config.Endpoints.ZapProperties = server.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(query string) model.ListPropertyResponseV2 {
+		req, err := http.NewRequest("GET", "/v2/properties?limit=20&"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", "zap")
+		response := executeRouterRequest(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		listing := model.ListPropertyResponseV2{}
+		json.Unmarshal(response.Body.Bytes(), &listing)
+		return listing
+	}
+
+	listing := request("")
+	groups := map[string]string{}
+	for _, property := range listing.Properties {
+		groups[property.Id] = property.DuplicateGroupId
+	}
+	assert.NotEmpty(t, groups["a3"])
+	assert.Equal(t, groups["a3"], groups["a7"])
+	assert.Equal(t, groups["a3"], groups["a8"])
+	assert.Empty(t, groups["a9"])
+	assert.Empty(t, groups["a1"])
+	// The v1 does not answer the groups
+	req, err := http.NewRequest("GET", "/v1/properties?limit=20", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	response := executeRouterRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotContains(t, response.Body.String(), "duplicateGroupId")
+
+	collapsed := request("collapseDuplicates=true")
+	assert.Equal(t, listing.PropertiesTotalCount-2, collapsed.PropertiesTotalCount)
+	ids := []string{}
+	for _, property := range collapsed.Properties {
+		ids = append(ids, property.Id)
+	}
+	assert.Contains(t, ids, "a3")
+	assert.NotContains(t, ids, "a7")
+	assert.NotContains(t, ids, "a8")
+}
+
+// TestQualityScore tests the scores by the weights of every source, and the orders of the Properties
+func TestQualityScore(t *testing.T) {
+	file, restore := useConfigFile(t)
+	defer restore()
+	// The campaigns of the zone set the updatedAt of the Properties of the fixture to the ingestion
+	ioutil.WriteFile(file, []byte("[zones.grupozap]\nmin_lon = -47.0\nmin_lat = -24.0\nmax_lon = -46.0\nmax_lat = -23.0\n"), 0644)
+	assert.Nil(t, a.Reload(context.Background()))
+	defer useFixture(t)()
+	quality := (*a.Config.Quality)["zap"]
+	(*a.Config.Quality)["zap"] = &config.Quality{Location: 1, MaxImages: 10, StaleAfter: time.Hour}
+	defer func() { (*a.Config.Quality)["zap"] = quality }()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(source, query string) (*httptest.ResponseRecorder, []string, []float64) {
+		req, err := http.NewRequest("GET", "/v2/properties?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", source)
+		response := executeRouterRequest(req)
+		listing := model.ListPropertyResponseV2{}
+		json.Unmarshal(response.Body.Bytes(), &listing)
+		ids, scores := []string{}, []float64{}
+		for _, property := range listing.Properties {
+			ids = append(ids, property.Id)
+			scores = append(scores, property.QualityScore)
+		}
+		return response, ids, scores
+	}
+
+	_, ids, scores := request("zap", "")
+	assert.Equal(t, []string{"a1", "a3", "a4", "a2"}, ids)
+	assert.Equal(t, []float64{100, 100, 100, 0}, scores)
+	_, relevance, _ := request("zap", "sort=relevance")
+	assert.Equal(t, ids, relevance)
+	_, ids, _ = request("zap", "sort=newest")
+	assert.Equal(t, []string{"a3", "a1", "a2", "a4"}, ids)
+
+	// The images, the location and the pricing of a3 are scored by the default weights, it is not recent
+	_, ids, scores = request("vivareal", "")
+	assert.Equal(t, "a3", ids[0])
+	assert.Equal(t, 59.0, scores[0])
+
+	// The recency is of the upstream updatedAt, not of the ingestion set by the campaigns
+	(*a.Config.Quality)["zap"] = &config.Quality{Recency: 1, MaxImages: 10, StaleAfter: time.Hour}
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	_, _, scores = request("zap", "")
+	assert.Equal(t, []float64{0, 0, 0, 0}, scores)
+
+	response, _, _ := request("zap", "sort=cheapest")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// The newest are ordered by the IDs on the same updatedAt, and the updatedAt not parsed are the last
+	fixture, err := ioutil.ReadFile("testdata/properties.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := []model.Property{}
+	assert.Nil(t, json.Unmarshal(fixture, &properties))
+	for i := range properties {
+		switch properties[i].Id {
+		case "a1":
+			properties[i].UpdatedAt = "yesterday"
+		case "a2":
+			properties[i].UpdatedAt = "2018-03-20T10:00:00Z"
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(properties)
+	}))
+	defer server.Close()
+	a.Config.Endpoints.ZapProperties = server.URL
+	(*a.Config.Quality)["zap"] = &config.Quality{Location: 1, MaxImages: 10, StaleAfter: time.Hour}
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	_, ids, _ = request("zap", "")
+	assert.Equal(t, []string{"a1", "a3", "a4", "a2"}, ids)
+	_, ids, _ = request("zap", "sort=newest")
+	assert.Equal(t, []string{"a3", "a2", "a4", "a1"}, ids)
+
+	// The v1 does not answer the score
+	req, err := http.NewRequest("GET", "/v1/properties", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("source", "zap")
+	response = executeRouterRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotContains(t, response.Body.String(), "qualityScore")
+}
+
+// TestAnomalies tests the outliers of the price per square meter, the condo fees above the rent and the quarantine
+func TestAnomalies(t *testing.T) {
+	defer useFixture(t)()
+	fixture, err := ioutil.ReadFile("testdata/properties.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := []model.Property{}
+	assert.Nil(t, json.Unmarshal(fixture, &properties))
+	for i, price := range []string{"1450000", "1550000", "1480000", "1520000", "3000000", "650000"} {
+		property := properties[2]
+		property.Id = "m" + strconv.Itoa(i+1)
+		property.PricingInfos.Price = price
+		properties = append(properties, property)
+	}
+	condoFee := properties[3]
+	condoFee.Id = "c1"
+	condoFee.PricingInfos.MonthlyCondoFee = "6000"
+	properties = append(properties, condoFee)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(properties)
+	}))
+	defer server.Close()
+	a.Config.Endpoints.ZapProperties = server.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("source", "zap")
+		req.Header.Set("X-API-Key", adminKey)
+		return executeRouterRequest(req)
+	}
+	flagged := func(query string) map[string][]model.Anomaly {
+		listing := model.ListPropertyResponseV2{}
+		json.Unmarshal(request("/v2/properties?limit=20&"+query).Body.Bytes(), &listing)
+		anomalies := map[string][]model.Anomaly{}
+		for _, property := range listing.Properties {
+			anomalies[property.Id] = property.Anomalies
+		}
+		return anomalies
+	}
+
+	anomalies := flagged("anomalies=true")
+	assert.Equal(t, map[string][]model.Anomaly{
+		"a2": {{Reason: "price_per_square_meter_low", Severity: "medium", Score: -5.49}},
+		"m5": {{Reason: "price_per_square_meter_high", Severity: "high", Score: 20.37}},
+		"m6": {{Reason: "price_per_square_meter_low", Severity: "high", Score: -11.33}},
+		"c1": {{Reason: "condo_fee_above_rent", Severity: "high"}},
+	}, anomalies)
+	assert.Len(t, flagged("anomalies=false"), 7)
+	// The v1 filters by the anomalies but does not answer them
+	response := request("/v1/properties?limit=20&anomalies=true")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"id":"m5"`)
+	assert.NotContains(t, response.Body.String(), "price_per_square_meter_high")
+
+	a.Config.Anomalies.Quarantine = "high"
+	defer func() { a.Config.Anomalies.Quarantine = "" }()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	anomalies = flagged("")
+	assert.Contains(t, anomalies, "a2")
+	assert.NotContains(t, anomalies, "m5")
+	assert.NotContains(t, anomalies, "c1")
+
+	defer useAdminKey(t)()
+	response = request("/admin/quarantine")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Nil(t, a.Document.ValidateResponse(a.Document.Operation("/admin/quarantine", "GET"), response.Code, response.Body.Bytes()))
+	quarantine := model.Quarantine{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &quarantine))
+	ids := []string{}
+	for _, property := range quarantine.Sources["zap"] {
+		ids = append(ids, property.Id)
+	}
+	assert.ElementsMatch(t, []string{"m5", "m6", "c1"}, ids)
+}
+
+// TestStream tests the events of the changes pushed to the stream, and its resume by the Last-Event-ID
+func TestStream(t *testing.T) {
+	defer useFixture(t)()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+	open := func(lastEventID string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+"/v2/stream/zap", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	// read returns the ID and the event of the next events
+	read := func(scanner *bufio.Scanner, count int) [][2]string {
+		events := [][2]string{}
+		id := ""
+		for len(events) < count && scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "id: ") {
+				id = strings.TrimPrefix(line, "id: ")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				events = append(events, [2]string{id, strings.TrimPrefix(line, "event: ")})
+			}
+		}
+		return events
+	}
+
+	response := open("")
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	changed := serveChangedFixture(t)
+	defer changed.Close()
+	a.Config.Endpoints.ZapProperties = changed.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+
+	events := read(bufio.NewScanner(response.Body), 3)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "listing.price_changed", events[0][1])
+	assert.True(t, strings.HasSuffix(events[0][0], "-a1"), events[0][0])
+	assert.Equal(t, "listing.removed", events[1][1])
+	assert.Equal(t, "listing.added", events[2][1])
+
+	resumed := open(events[0][0])
+	defer resumed.Body.Close()
+	assert.Equal(t, events[1:], read(bufio.NewScanner(resumed.Body), 2))
+
+	gone := open("1-a1")
+	gone.Body.Close()
+	assert.Equal(t, http.StatusGone, gone.StatusCode)
+}
+
+// TestStreamWriteTimeout tests the stream is kept open longer than the write timeout of the server, through the compression
+func TestStreamWriteTimeout(t *testing.T) {
+	defer useFixture(t)()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	heartbeat := a.Config.Stream.Heartbeat
+	a.Config.Stream.Heartbeat = 50 * time.Millisecond
+	defer func() { a.Config.Stream.Heartbeat = heartbeat }()
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	defer server.Close()
+	req, err := http.NewRequest("GET", server.URL+"/v2/stream/zap", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	response, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+	body, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(body)
+	deadline := time.Now().Add(4 * server.Config.WriteTimeout)
+	for time.Now().Before(deadline) {
+		if !scanner.Scan() {
+			t.Fatalf("stream closed after %v: %v", server.Config.WriteTimeout, scanner.Err())
+		}
+	}
+	line := ""
+	for line == "" && scanner.Scan() {
+		line = scanner.Text()
+	}
+	assert.Equal(t, ": heartbeat", line)
+}
+
+// TestSavedSearches tests the webhooks of the new Properties matching the saved searches, and their retries
+func TestSavedSearches(t *testing.T) {
+	defer useFixture(t)()
+	directory, err := ioutil.TempDir("", "zap-api-searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	previous := *a.Config.Searches
+	a.Config.Searches.File = directory + "/searches.json"
+	a.Config.Searches.MaxAttempts = 2
+	a.Config.Searches.Backoff = time.Millisecond
+	defer func() { *a.Config.Searches = previous }()
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", adminKey)
+		return executeRouterRequest(req)
+	}
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer webhook.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	response := request("POST", "/v2/searches", `{"name":"Moema","source":"zap","filters":{"minPrice":"1000000"},`+
+		`"radius":{"lat":-23.61,"lon":-46.67,"meters":500},"webhookUrl":"`+webhook.URL+`"}`)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	search := model.SavedSearch{}
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &search))
+	assert.Len(t, search.Secret, 64)
+	response = request("POST", "/v2/searches", `{"source":"zap","webhookUrl":"`+failing.URL+`"}`)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/v2/searches", `{"source":"zap","webhookUrl":"ftp://crm"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/v2/searches", `{"source":"zap","filters":{"maxPrice":"cheap"},"webhookUrl":"`+webhook.URL+`"}`).Code)
+
+	changed := serveChangedFixture(t)
+	defer changed.Close()
+	a.Config.Endpoints.ZapProperties = changed.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		signature := hmac.New(sha256.New, []byte(search.Secret))
+		parts := strings.Split(r.Header.Get("X-Signature"), ",")
+		signature.Write([]byte(strings.TrimPrefix(parts[0], "t=") + "."))
+		signature.Write(body)
+		assert.Equal(t, "v1="+hex.EncodeToString(signature.Sum(nil)), parts[1])
+		match := model.SearchMatch{}
+		assert.Nil(t, json.Unmarshal(body, &match))
+		assert.Equal(t, search.Id, match.SearchId)
+		assert.Len(t, match.Listings, 1)
+		assert.Equal(t, "a6", match.Listings[0].Id)
+		assert.Equal(t, match.DeliveryId, r.Header.Get("X-Delivery-ID"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not received")
+	}
+	deliveries := model.Deliveries{}
+	for i := 0; i < 100 && (len(deliveries.Deliveries) == 0 || deliveries.Deliveries[0].Status != "delivered"); i++ {
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal(request("GET", "/v2/searches/"+search.Id+"/deliveries", "").Body.Bytes(), &deliveries)
+	}
+	assert.Equal(t, "delivered", deliveries.Deliveries[0].Status)
+	assert.Equal(t, []string{"a6"}, deliveries.Deliveries[0].Listings)
+
+	restoreKeys := useAdminKey(t)
+	dead := model.Deliveries{}
+	for i := 0; i < 100 && len(dead.Deliveries) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal(request("GET", "/admin/dead-letters", "").Body.Bytes(), &dead)
+	}
+	assert.Len(t, dead.Deliveries, 1)
+	assert.Equal(t, 2, dead.Deliveries[0].Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, dead.Deliveries[0].LastStatusCode)
+	restoreKeys()
+
+	// The searches are loaded again from the file
+	a.Config.Searches.File = directory + "/other.json"
+	assert.Equal(t, `{"searches":[]}`, strings.TrimSpace(request("GET", "/v2/searches", "").Body.String()))
+	a.Config.Searches.File = directory + "/searches.json"
+	searches := model.SavedSearches{}
+	assert.Nil(t, json.Unmarshal(request("GET", "/v2/searches", "").Body.Bytes(), &searches))
+	assert.Len(t, searches.Searches, 2)
+	assert.Empty(t, searches.Searches[0].Secret)
+
+	response = request("PUT", "/v2/searches/"+search.Id, `{"name":"Moema SALE","source":"zap","webhookUrl":"`+webhook.URL+`"}`)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "Moema SALE")
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/v2/searches/"+search.Id, "").Code)
+	assert.Equal(t, http.StatusNotFound, request("GET", "/v2/searches/"+search.Id, "").Code)
+}
+
+// TestWebhookAddresses tests the webhooks to the addresses not public are refused, on the creation and on the delivery,
+// and the redirects of the webhooks are not followed
+func TestWebhookAddresses(t *testing.T) {
+	defer useFixture(t)()
+	directory, err := ioutil.TempDir("", "zap-api-searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	previous := *a.Config.Searches
+	a.Config.Searches.File = directory + "/searches.json"
+	a.Config.Searches.MaxAttempts = 1
+	defer func() { *a.Config.Searches = previous }()
+	fixture := a.Config.Endpoints.ZapProperties
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-API-Key", adminKey)
+		return executeRouterRequest(req)
+	}
+	received := make(chan string, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Path
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL+"/redirected", http.StatusFound))
+	defer redirect.Close()
+	deadLetters := func(count int) []model.Delivery {
+		defer useAdminKey(t)()
+		dead := model.Deliveries{}
+		for i := 0; i < 100 && len(dead.Deliveries) < count; i++ {
+			time.Sleep(10 * time.Millisecond)
+			json.Unmarshal(request("GET", "/admin/dead-letters", "").Body.Bytes(), &dead)
+		}
+		return dead.Deliveries
+	}
+
+	a.Config.Searches.AllowPrivateWebhooks = false
+	for _, webhookURL := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hook", "http://[::1]/hook", "http://0.0.0.0/hook"} {
+		response := request("POST", "/v2/searches", `{"source":"zap","webhookUrl":"`+webhookURL+`"}`)
+		assert.Equal(t, http.StatusBadRequest, response.Code, webhookURL)
+		assert.Equal(t, `{"error":"Webhook URL not accepted: the host must be public."}`, response.Body.String(), webhookURL)
+	}
+
+	a.Config.Searches.AllowPrivateWebhooks = true
+	assert.Equal(t, http.StatusCreated, request("POST", "/v2/searches", `{"source":"zap","webhookUrl":"`+redirect.URL+`"}`).Code)
+	changed := serveChangedFixture(t)
+	defer changed.Close()
+	a.Config.Endpoints.ZapProperties = changed.URL
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	dead := deadLetters(1)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, http.StatusFound, dead[0].LastStatusCode)
+
+	// The address of the host is checked again when it is connected, like a host resolved to another address
+	assert.Equal(t, http.StatusCreated, request("POST", "/v2/searches", `{"source":"zap","webhookUrl":"`+target.URL+`"}`).Code)
+	a.Config.Searches.AllowPrivateWebhooks = false
+	a.Config.Endpoints.ZapProperties = fixture
+	assert.Nil(t, handler.Ingest(context.Background(), a.Config))
+	dead = deadLetters(3)
+	assert.Len(t, dead, 3)
+	for _, delivery := range dead[:2] {
+		assert.Zero(t, delivery.LastStatusCode)
+		assert.Contains(t, delivery.LastError, "not public")
+	}
+	select {
+	case path := <-received:
+		t.Fatalf("webhook received on %s", path)
+	default:
+	}
+}
+
+// serveChangedFixture serves the fixture with the price of a1 changed, a2 removed and a6 added
+func serveChangedFixture(t *testing.T) *httptest.Server {
+	fixture, err := ioutil.ReadFile("testdata/properties.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := []model.Property{}
+	assert.Nil(t, json.Unmarshal(fixture, &properties))
+	properties[0].PricingInfos.Price = "720000"
+	added := properties[2]
+	added.Id = "a6"
+	properties = append(properties[:1], append(properties[2:], added)...)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(properties)
+	}))
+}
+
+// adminKey is the API key of the admin client configured by useAdminKey
+const adminKey = "admin-key"
+
+// useAdminKey configures the keys file of an admin client, the administration is closed without one,
+// and returns the function to restore it, the file is kept by the reloads by AUTH_KEYS_FILE
+func useAdminKey(t *testing.T) func() {
+	file, err := ioutil.TempFile("", "zap-api-*.keys.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("[clients.ops]\nkey_hash = \"" + hashKey(adminKey) + "\"\nsources = [\"zap\"]\nadmin = true\n")
+	file.Close()
+	os.Setenv("AUTH_KEYS_FILE", file.Name())
+	a.Config.Auth.KeysFile = file.Name()
+	a.Config.Clients = map[string]*config.Client{"ops": {KeyHash: hashKey(adminKey), Sources: []string{"zap"}, Admin: true}}
+	return func() {
+		os.Unsetenv("AUTH_KEYS_FILE")
+		os.Remove(file.Name())
+		a.Config.Auth.KeysFile = ""
+		a.Config.Clients = nil
+	}
+}
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// useConfigFile points the reloads to an empty configuration file and returns the function to restore it
+func useConfigFile(t *testing.T) (string, func()) {
+	file, err := ioutil.TempFile("", "zap-api-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	os.Setenv("CONFIG_FILE", file.Name())
+	return file.Name(), func() {
+		os.Unsetenv("CONFIG_FILE")
+		os.Remove(file.Name())
+		a.Reload(context.Background())
+	}
+}
+
+// useFixture points the API to a local copy of the Properties and returns the function to restore it
+func useFixture(t *testing.T) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/properties.json")
+	}))
+	endpoint := a.Config.Endpoints.ZapProperties
+	a.Config.Endpoints.ZapProperties = server.URL
+	a.Config.Cache.Flush()
+	return func() {
+		a.Config.Endpoints.ZapProperties = endpoint
+		a.Config.Cache.Flush()
+		server.Close()
+	}
+}
+
+func executeHandlerRequest(req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rr, req)
+	return rr
+}
+
+func executeRouterRequest(req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	a.Router.ServeHTTP(rr, req)
+	return rr
+}